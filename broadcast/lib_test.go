@@ -1,16 +1,20 @@
 package broadcast
 
 import (
+	"encoding/json"
+	"slices"
 	"testing"
 	"time"
 
+	"gossip-glomers/crdt"
+
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
 func TestRead(t *testing.T) {
 	n := maelstrom.NewNode()
-	server := NewBroadcastServer(n, time.Second, time.Second)
-	server.messages.Store(20, struct{}{})
+	server := NewBroadcastServer(n, time.Second)
+	server.Broadcast(&BroadcastMessage{MessageType: "broadcast", Message: 20})
 	readMessage := ReadMessage{MessageType: "read"}
 
 	reply := server.Read(&readMessage)
@@ -22,59 +26,71 @@ func TestRead(t *testing.T) {
 
 func TestBroadcastNewMessage(t *testing.T) {
 	n := maelstrom.NewNode()
-	server := NewBroadcastServer(n, time.Second, time.Second)
+	server := NewBroadcastServer(n, time.Second)
 	broadcastMessage := BroadcastMessage{MessageType: "broadcast", Message: 1000, MessageID: 1}
 
-	broadcastReply, replyBack := server.Broadcast(&broadcastMessage, "c2")
-
-	if replyBack == false {
-		t.Fail()
-	}
+	broadcastReply := server.Broadcast(&broadcastMessage)
 
 	if broadcastReply.MessageType != "broadcast_ok" {
 		t.Fail()
 	}
 
-	if _, ok := server.messages.Load(1000); !ok {
-		t.Fail()
-	}
-
-	select {
-	case message := <-server.passingChannel:
-		if message.int != 1000 || message.string != "c2" {
-			t.Fail()
+	server.replicator.Update(func(set *crdt.GSet[int]) {
+		if delta, ok := set.Delta(); !ok || !slices.Equal(delta.Elements(), []int{1000}) {
+			t.Errorf("expected 1000 to be gossiped on the next tick")
 		}
-	case <-time.After(time.Millisecond * 5):
-		t.Fail()
-	}
+	})
 }
 
 func TestBroadcastDuplicateMessage(t *testing.T) {
 	n := maelstrom.NewNode()
-	server := NewBroadcastServer(n, time.Second, time.Second)
-	server.messages.Store(1000, struct{}{})
-	broadcastMessage := BroadcastMessage{MessageType: "broadcast", Message: 1000}
+	server := NewBroadcastServer(n, time.Second)
+	broadcastMessage := BroadcastMessage{MessageType: "broadcast", Message: 1000, MessageID: 1}
+	server.Broadcast(&broadcastMessage)
+	server.replicator.Update(func(set *crdt.GSet[int]) { set.Delta() })
 
-	broadcastReply, replyBack := server.Broadcast(&broadcastMessage, "n2")
+	broadcastReply := server.Broadcast(&broadcastMessage)
 
-	if replyBack == true {
+	if broadcastReply.MessageType != "broadcast_ok" {
 		t.Fail()
 	}
 
-	if broadcastReply.MessageType != "broadcast_ok" {
-		t.Fail()
+	server.replicator.Update(func(set *crdt.GSet[int]) {
+		if _, ok := set.Delta(); ok {
+			t.Errorf("expected a known message not to be gossiped again")
+		}
+	})
+}
+
+func TestGossipMergesMessages(t *testing.T) {
+	n := maelstrom.NewNode()
+	server := NewBroadcastServer(n, time.Second)
+	server.Broadcast(&BroadcastMessage{MessageType: "broadcast", Message: 1})
+
+	peer := crdt.NewGSet[int]()
+	peer.Add(1)
+	peer.Add(2)
+	state, err := json.Marshal(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(crdt.GossipMessage{MessageType: server.GossipMessageType(), State: state})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	select {
-	case <-server.passingChannel:
-		t.Fail()
-	case <-time.After(time.Millisecond * 2):
+	if err := server.HandleGossip(maelstrom.Message{Src: "n1", Dest: "n0", Body: body}); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply := server.Read(&ReadMessage{MessageType: "read"}); !slices.Equal(reply.Messages, []int{1, 2}) {
+		t.Errorf("expected [1 2] but read %v", reply.Messages)
 	}
 }
 
 func TestTopology(t *testing.T) {
 	n := maelstrom.NewNode()
-	server := NewBroadcastServer(n, time.Second, time.Second)
+	server := NewBroadcastServer(n, time.Second)
 	topologyMessage := TopologyMessage{MessageType: "topology", Topology: map[string][]string{"n1": {"n2", "n3"}, "": {"n1"}}}
 
 	toplogyReply := server.Topology(&topologyMessage)
@@ -46,9 +46,3 @@ func (m *BroadcastMessage) Reply() BroadcastMessageReply {
 		MessageType: "broadcast_ok",
 	}
 }
-
-type GossipMessage struct {
-	MessageType string `json:"type"`
-	Messages    []int  `json:"messages"`
-	MessageID   int    `json:"msg_id"`
-}
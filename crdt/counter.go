@@ -0,0 +1,134 @@
+package crdt
+
+import (
+	"encoding/json"
+	"maps"
+)
+
+// GCounter is a grow-only counter with one slot per node.
+type GCounter struct {
+	counts map[string]int
+	delta  map[string]int
+}
+
+func NewGCounter() *GCounter {
+	return &GCounter{
+		counts: make(map[string]int),
+		delta:  make(map[string]int),
+	}
+}
+
+func (c *GCounter) Increment(node string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	c.counts[node] += n
+	c.delta[node] = c.counts[node]
+}
+
+func (c *GCounter) Value() int {
+	value := 0
+	for _, count := range c.counts {
+		value += count
+	}
+	return value
+}
+
+func (c *GCounter) Merge(other *GCounter) {
+	for node, count := range other.counts {
+		if count > c.counts[node] {
+			c.counts[node] = count
+		}
+	}
+}
+
+func (c *GCounter) Delta() (*GCounter, bool) {
+	if len(c.delta) == 0 {
+		return nil, false
+	}
+
+	delta := &GCounter{counts: c.delta, delta: make(map[string]int)}
+	c.delta = make(map[string]int)
+	return delta, true
+}
+
+func (c *GCounter) Clone() *GCounter {
+	return &GCounter{counts: maps.Clone(c.counts), delta: make(map[string]int)}
+}
+
+func (c *GCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.counts)
+}
+
+func (c *GCounter) UnmarshalJSON(data []byte) error {
+	c.counts = make(map[string]int)
+	c.delta = make(map[string]int)
+	return json.Unmarshal(data, &c.counts)
+}
+
+// PNCounter supports decrements through a second GCounter of decrements.
+type PNCounter struct {
+	increments *GCounter
+	decrements *GCounter
+}
+
+type pnCounterJSON struct {
+	Increments *GCounter `json:"p"`
+	Decrements *GCounter `json:"n"`
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{increments: NewGCounter(), decrements: NewGCounter()}
+}
+
+func (c *PNCounter) Increment(node string, delta int) {
+	if delta < 0 {
+		c.decrements.Increment(node, -delta)
+	} else {
+		c.increments.Increment(node, delta)
+	}
+}
+
+func (c *PNCounter) Value() int {
+	return c.increments.Value() - c.decrements.Value()
+}
+
+func (c *PNCounter) Merge(other *PNCounter) {
+	c.increments.Merge(other.increments)
+	c.decrements.Merge(other.decrements)
+}
+
+func (c *PNCounter) Delta() (*PNCounter, bool) {
+	increments, incremented := c.increments.Delta()
+	decrements, decremented := c.decrements.Delta()
+	if !incremented && !decremented {
+		return nil, false
+	}
+
+	if !incremented {
+		increments = NewGCounter()
+	}
+	if !decremented {
+		decrements = NewGCounter()
+	}
+	return &PNCounter{increments: increments, decrements: decrements}, true
+}
+
+func (c *PNCounter) Clone() *PNCounter {
+	return &PNCounter{increments: c.increments.Clone(), decrements: c.decrements.Clone()}
+}
+
+func (c *PNCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pnCounterJSON{Increments: c.increments, Decrements: c.decrements})
+}
+
+func (c *PNCounter) UnmarshalJSON(data []byte) error {
+	decoded := pnCounterJSON{Increments: NewGCounter(), Decrements: NewGCounter()}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	c.increments, c.decrements = decoded.Increments, decoded.Decrements
+	return nil
+}
@@ -0,0 +1,137 @@
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const FULL_STATE_ROUNDS int = 10
+
+// CRDT is a delta-state convergent data type. Merge has to be commutative,
+// associative and idempotent. Delta returns the local changes made since the
+// previous call, or false if there were none, and Clone a copy of the whole
+// state. Implementations also have to round trip through encoding/json.
+type CRDT[T any] interface {
+	Merge(other T)
+	Delta() (T, bool)
+	Clone() T
+}
+
+type GossipMessage struct {
+	MessageType string          `json:"type"`
+	State       json.RawMessage `json:"state"`
+	Full        bool            `json:"full"`
+}
+
+// Replicator owns a CRDT on a node and keeps it converged with the copies on
+// the other nodes. Every gossip tick it ships the local delta to all peers,
+// and every FULL_STATE_ROUNDS ticks the full state so replicas that lost a
+// delta catch up again.
+type Replicator[T CRDT[T]] struct {
+	n                  *maelstrom.Node
+	messageType        string
+	state              T
+	empty              func() T
+	lock               *sync.RWMutex
+	gossipTickDuration time.Duration
+}
+
+func NewReplicator[T CRDT[T]](n *maelstrom.Node, messageType string, empty func() T, gossipTickDuration time.Duration) Replicator[T] {
+	return Replicator[T]{
+		n:                  n,
+		messageType:        messageType,
+		state:              empty(),
+		empty:              empty,
+		lock:               &sync.RWMutex{},
+		gossipTickDuration: gossipTickDuration,
+	}
+}
+
+func (r *Replicator[T]) MessageType() string {
+	return r.messageType
+}
+
+// Update runs fn with exclusive access to the state, for local changes.
+func (r *Replicator[T]) Update(fn func(state T)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fn(r.state)
+}
+
+// View runs fn with shared access to the state, fn must not modify it.
+func (r *Replicator[T]) View(fn func(state T)) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	fn(r.state)
+}
+
+func (r *Replicator[T]) Merge(other T) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.state.Merge(other)
+}
+
+// HandleGossip is the maelstrom handler for the replicator's message type.
+func (r *Replicator[T]) HandleGossip(msg maelstrom.Message) error {
+	gossipMessage := new(GossipMessage)
+	if err := json.Unmarshal(msg.Body, gossipMessage); err != nil {
+		return err
+	}
+
+	received := r.empty()
+	if err := json.Unmarshal(gossipMessage.State, received); err != nil {
+		return err
+	}
+
+	r.Merge(received)
+	return nil
+}
+
+func (r *Replicator[T]) nextGossip(round int) (T, bool, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delta, changed := r.state.Delta()
+	if round%FULL_STATE_ROUNDS == 0 {
+		return r.state.Clone(), true, true
+	}
+	return delta, changed, false
+}
+
+func (r *Replicator[T]) Gossiper(ctx context.Context) {
+	gossipTicker := time.NewTicker(r.gossipTickDuration)
+	defer gossipTicker.Stop()
+	for round := 1; ; round++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gossipTicker.C:
+			state, ok, full := r.nextGossip(round)
+			if !ok {
+				continue
+			}
+
+			raw, err := json.Marshal(state)
+			if err != nil {
+				log.Printf("failed to encode %s state: %v", r.messageType, err)
+				continue
+			}
+
+			gossipMessage := GossipMessage{MessageType: r.messageType, State: raw, Full: full}
+			for _, dest := range r.n.NodeIDs() {
+				if dest == r.n.ID() {
+					continue
+				}
+
+				if err := r.n.Send(dest, gossipMessage); err != nil {
+					log.Printf("failed to gossip %s to %s: %v", r.messageType, dest, err)
+				}
+			}
+		}
+	}
+}
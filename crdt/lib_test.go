@@ -0,0 +1,181 @@
+package crdt
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func roundTrip[T CRDT[T]](t *testing.T, value T, empty T) T {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(raw, empty); err != nil {
+		t.Fatal(err)
+	}
+	return empty
+}
+
+func TestGCounterDeltaMerge(t *testing.T) {
+	first, second := NewGCounter(), NewGCounter()
+	first.Increment("n0", 3)
+	second.Increment("n1", 4)
+
+	delta, ok := first.Delta()
+	if !ok {
+		t.Fatal("expected a delta after increment")
+	}
+	second.Merge(roundTrip(t, delta, NewGCounter()))
+	second.Merge(delta)
+
+	if second.Value() != 7 {
+		t.Errorf("expected 7 but was %d", second.Value())
+	}
+
+	if _, ok := first.Delta(); ok {
+		t.Error("delta not cleared after extraction")
+	}
+}
+
+func TestPNCounterMerge(t *testing.T) {
+	first, second := NewPNCounter(), NewPNCounter()
+	first.Increment("n0", 10)
+	second.Increment("n1", -4)
+
+	first.Merge(roundTrip(t, second.Clone(), NewPNCounter()))
+	second.Merge(first.Clone())
+
+	if first.Value() != 6 || second.Value() != 6 {
+		t.Errorf("expected both counters to be 6 but were %d and %d", first.Value(), second.Value())
+	}
+}
+
+func TestGSetMerge(t *testing.T) {
+	first, second := NewGSet[int](), NewGSet[int]()
+	first.Add(1)
+	second.Add(2)
+
+	delta, _ := first.Delta()
+	second.Merge(roundTrip(t, delta, NewGSet[int]()))
+
+	elements := second.Elements()
+	slices.Sort(elements)
+	if !slices.Equal(elements, []int{1, 2}) {
+		t.Errorf("unexpected elements %v", elements)
+	}
+}
+
+func TestORSetAddWins(t *testing.T) {
+	first, second := NewORSet[string](), NewORSet[string]()
+	first.Add("n0", "x")
+	second.Merge(first.Clone())
+
+	// concurrent remove on one replica and re-add on the other
+	second.Remove("x")
+	first.Add("n0", "x")
+
+	firstDelta, _ := first.Delta()
+	secondDelta, _ := second.Delta()
+	first.Merge(roundTrip(t, secondDelta, NewORSet[string]()))
+	second.Merge(roundTrip(t, firstDelta, NewORSet[string]()))
+
+	if !first.Contains("x") || !second.Contains("x") {
+		t.Error("concurrent add should win over remove")
+	}
+
+	first.Remove("x")
+	second.Merge(first.Clone())
+	if second.Contains("x") || len(second.Elements()) != 0 {
+		t.Error("observed remove not applied")
+	}
+}
+
+func TestLWWRegisterMerge(t *testing.T) {
+	first, second := NewLWWRegister[string](), NewLWWRegister[string]()
+	first.Set("old", Timestamp{Time: 1, Node: "n0"})
+	second.Set("new", Timestamp{Time: 1, Node: "n1"})
+
+	first.Merge(roundTrip(t, second.Clone(), NewLWWRegister[string]()))
+	second.Merge(first.Clone())
+
+	for _, register := range []*LWWRegister[string]{first, second} {
+		if value, _, _ := register.Get(); value != "new" {
+			t.Errorf("expected the write of n1 to win but got %s", value)
+		}
+	}
+}
+
+func TestLWWRegisterOrdersLogicalCounter(t *testing.T) {
+	register := NewLWWRegister[string]()
+	register.Set("later", Timestamp{Time: 1, Logical: 1, Node: "n0"})
+
+	if register.Set("earlier", Timestamp{Time: 1, Node: "n1"}) {
+		t.Error("expected a write with a smaller logical counter to lose")
+	}
+	if value, _, _ := register.Get(); value != "later" {
+		t.Errorf("expected later but got %s", value)
+	}
+}
+
+func TestLWWMapMerge(t *testing.T) {
+	first, second := NewLWWMap[int, int](), NewLWWMap[int, int]()
+	first.Set(1, 10, Timestamp{Time: 2, Node: "n0"})
+	second.Set(1, 20, Timestamp{Time: 1, Node: "n1"})
+	second.Set(2, 30, Timestamp{Time: 1, Node: "n1"})
+
+	firstDelta, _ := first.Delta()
+	secondDelta, _ := second.Delta()
+	first.Merge(roundTrip(t, secondDelta, NewLWWMap[int, int]()))
+	second.Merge(roundTrip(t, firstDelta, NewLWWMap[int, int]()))
+
+	for _, m := range []*LWWMap[int, int]{first, second} {
+		one, _, _ := m.Get(1)
+		two, _, _ := m.Get(2)
+		if one != 10 || two != 30 {
+			t.Errorf("expected {1:10, 2:30} but got {1:%d, 2:%d}", one, two)
+		}
+	}
+}
+
+func TestMapDeltaCarriesCreatedKeys(t *testing.T) {
+	local, remote := NewMap(NewGCounter), NewMap(NewGCounter)
+	local.Get("idle").Increment("n0", 0)
+
+	delta, ok := local.Delta()
+	if !ok {
+		t.Fatal("expected a delta after creating a key")
+	}
+	remote.Merge(roundTrip(t, delta, NewMap(NewGCounter)))
+
+	if _, ok := remote.Lookup("idle"); !ok {
+		t.Errorf("expected the created key to be merged but got %v", remote.Keys())
+	}
+	if _, ok := local.Delta(); ok {
+		t.Error("delta not cleared after extraction")
+	}
+}
+
+func TestReplicatorHandleGossip(t *testing.T) {
+	n := maelstrom.NewNode()
+	replicator := NewReplicator(n, "counters_gossip", func() *Map[*GCounter] { return NewMap(NewGCounter) }, time.Second)
+
+	remote := NewMap(NewGCounter)
+	remote.Get("requests").Increment("n1", 5)
+	state, _ := json.Marshal(remote)
+	body, _ := json.Marshal(GossipMessage{MessageType: "counters_gossip", State: state})
+
+	if err := replicator.HandleGossip(maelstrom.Message{Src: "n1", Body: body}); err != nil {
+		t.Fatal(err)
+	}
+
+	replicator.View(func(state *Map[*GCounter]) {
+		if counter, ok := state.Lookup("requests"); !ok || counter.Value() != 5 {
+			t.Errorf("gossiped counter not merged")
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package crdt
+
+import "encoding/json"
+
+// Timestamp orders writes to LWW types, ties on Time are broken by Logical,
+// the counter of a hybrid logical clock, and then by Node so all replicas
+// pick the same winner.
+type Timestamp struct {
+	Time    int64  `json:"time"`
+	Logical uint32 `json:"logical,omitempty"`
+	Node    string `json:"node"`
+}
+
+func (t Timestamp) Less(other Timestamp) bool {
+	if t.Time != other.Time {
+		return t.Time < other.Time
+	}
+	if t.Logical != other.Logical {
+		return t.Logical < other.Logical
+	}
+	return t.Node < other.Node
+}
+
+// LWWRegister holds the value of the write with the greatest timestamp.
+type LWWRegister[V any] struct {
+	value     V
+	timestamp Timestamp
+	set       bool
+	changed   bool
+}
+
+type lwwRegisterJSON[V any] struct {
+	Value     V         `json:"value"`
+	Timestamp Timestamp `json:"timestamp"`
+	Set       bool      `json:"set"`
+}
+
+func NewLWWRegister[V any]() *LWWRegister[V] {
+	return &LWWRegister[V]{}
+}
+
+// Set stores value if timestamp is newer than the current one and reports
+// whether it did.
+func (r *LWWRegister[V]) Set(value V, timestamp Timestamp) bool {
+	if r.set && !r.timestamp.Less(timestamp) {
+		return false
+	}
+
+	r.value, r.timestamp, r.set, r.changed = value, timestamp, true, true
+	return true
+}
+
+func (r *LWWRegister[V]) Get() (V, Timestamp, bool) {
+	return r.value, r.timestamp, r.set
+}
+
+func (r *LWWRegister[V]) Merge(other *LWWRegister[V]) {
+	if !other.set || (r.set && !r.timestamp.Less(other.timestamp)) {
+		return
+	}
+
+	r.value, r.timestamp, r.set = other.value, other.timestamp, true
+}
+
+func (r *LWWRegister[V]) Delta() (*LWWRegister[V], bool) {
+	if !r.changed {
+		return nil, false
+	}
+
+	r.changed = false
+	return r.Clone(), true
+}
+
+func (r *LWWRegister[V]) Clone() *LWWRegister[V] {
+	return &LWWRegister[V]{value: r.value, timestamp: r.timestamp, set: r.set}
+}
+
+func (r *LWWRegister[V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lwwRegisterJSON[V]{Value: r.value, Timestamp: r.timestamp, Set: r.set})
+}
+
+func (r *LWWRegister[V]) UnmarshalJSON(data []byte) error {
+	decoded := lwwRegisterJSON[V]{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	r.value, r.timestamp, r.set, r.changed = decoded.Value, decoded.Timestamp, decoded.Set, false
+	return nil
+}
+
+// LWWMap is a map of LWW registers, keys can be overwritten but not removed.
+type LWWMap[K comparable, V any] struct {
+	registers map[K]*LWWRegister[V]
+	delta     map[K]struct{}
+}
+
+type lwwMapEntryJSON[K comparable, V any] struct {
+	Key       K         `json:"key"`
+	Value     V         `json:"value"`
+	Timestamp Timestamp `json:"timestamp"`
+}
+
+func NewLWWMap[K comparable, V any]() *LWWMap[K, V] {
+	return &LWWMap[K, V]{
+		registers: make(map[K]*LWWRegister[V]),
+		delta:     make(map[K]struct{}),
+	}
+}
+
+func (m *LWWMap[K, V]) register(key K) *LWWRegister[V] {
+	register, ok := m.registers[key]
+	if !ok {
+		register = NewLWWRegister[V]()
+		m.registers[key] = register
+	}
+	return register
+}
+
+func (m *LWWMap[K, V]) Set(key K, value V, timestamp Timestamp) bool {
+	if !m.register(key).Set(value, timestamp) {
+		return false
+	}
+
+	m.delta[key] = struct{}{}
+	return true
+}
+
+func (m *LWWMap[K, V]) Get(key K) (V, Timestamp, bool) {
+	register, ok := m.registers[key]
+	if !ok {
+		var zero V
+		return zero, Timestamp{}, false
+	}
+	return register.Get()
+}
+
+func (m *LWWMap[K, V]) Keys() []K {
+	keys := make([]K, 0, len(m.registers))
+	for key := range m.registers {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *LWWMap[K, V]) Merge(other *LWWMap[K, V]) {
+	for key, register := range other.registers {
+		m.register(key).Merge(register)
+	}
+}
+
+func (m *LWWMap[K, V]) Delta() (*LWWMap[K, V], bool) {
+	if len(m.delta) == 0 {
+		return nil, false
+	}
+
+	delta := NewLWWMap[K, V]()
+	for key := range m.delta {
+		delta.registers[key] = m.registers[key].Clone()
+		m.registers[key].changed = false
+	}
+	m.delta = make(map[K]struct{})
+	return delta, true
+}
+
+func (m *LWWMap[K, V]) Clone() *LWWMap[K, V] {
+	clone := NewLWWMap[K, V]()
+	for key, register := range m.registers {
+		clone.registers[key] = register.Clone()
+	}
+	return clone
+}
+
+func (m *LWWMap[K, V]) MarshalJSON() ([]byte, error) {
+	entries := make([]lwwMapEntryJSON[K, V], 0, len(m.registers))
+	for key, register := range m.registers {
+		if !register.set {
+			continue
+		}
+		entries = append(entries, lwwMapEntryJSON[K, V]{Key: key, Value: register.value, Timestamp: register.timestamp})
+	}
+	return json.Marshal(entries)
+}
+
+func (m *LWWMap[K, V]) UnmarshalJSON(data []byte) error {
+	entries := make([]lwwMapEntryJSON[K, V], 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	*m = *NewLWWMap[K, V]()
+	for _, entry := range entries {
+		m.register(entry.Key).Merge(&LWWRegister[V]{value: entry.Value, timestamp: entry.Timestamp, set: true})
+	}
+	return nil
+}
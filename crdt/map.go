@@ -0,0 +1,90 @@
+package crdt
+
+import "encoding/json"
+
+// Map is a grow-only map of nested CRDTs merged key by key, e.g. one counter
+// per name. Keys created since the last delta are part of the next one, even
+// if their entry didn't change, so peers learn of every key.
+type Map[T CRDT[T]] struct {
+	entries  map[string]T
+	created  map[string]struct{}
+	newEntry func() T
+}
+
+func NewMap[T CRDT[T]](newEntry func() T) *Map[T] {
+	return &Map[T]{entries: make(map[string]T), created: make(map[string]struct{}), newEntry: newEntry}
+}
+
+// Get returns the entry for key, creating an empty one if needed.
+func (m *Map[T]) Get(key string) T {
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = m.newEntry()
+		m.entries[key] = entry
+		m.created[key] = struct{}{}
+	}
+	return entry
+}
+
+func (m *Map[T]) Lookup(key string) (T, bool) {
+	entry, ok := m.entries[key]
+	return entry, ok
+}
+
+func (m *Map[T]) Keys() []string {
+	keys := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *Map[T]) Merge(other *Map[T]) {
+	for key, entry := range other.entries {
+		m.Get(key).Merge(entry)
+	}
+}
+
+func (m *Map[T]) Delta() (*Map[T], bool) {
+	delta := NewMap(m.newEntry)
+	for key, entry := range m.entries {
+		if entryDelta, ok := entry.Delta(); ok {
+			delta.entries[key] = entryDelta
+		} else if _, ok := m.created[key]; ok {
+			delta.entries[key] = entry.Clone()
+		}
+	}
+	m.created = make(map[string]struct{})
+	return delta, len(delta.entries) > 0
+}
+
+func (m *Map[T]) Clone() *Map[T] {
+	clone := NewMap(m.newEntry)
+	for key, entry := range m.entries {
+		clone.entries[key] = entry.Clone()
+	}
+	return clone
+}
+
+func (m *Map[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.entries)
+}
+
+// UnmarshalJSON needs newEntry, so decode into a Map made with NewMap.
+func (m *Map[T]) UnmarshalJSON(data []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	m.entries = make(map[string]T, len(raw))
+	m.created = make(map[string]struct{})
+	for key, encoded := range raw {
+		entry := m.newEntry()
+		if err := json.Unmarshal(encoded, entry); err != nil {
+			return err
+		}
+		m.entries[key] = entry
+	}
+	return nil
+}
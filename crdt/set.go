@@ -0,0 +1,213 @@
+package crdt
+
+import (
+	"encoding/json"
+	"maps"
+	"strconv"
+)
+
+// GSet is a grow-only set.
+type GSet[E comparable] struct {
+	elements map[E]struct{}
+	delta    map[E]struct{}
+}
+
+func NewGSet[E comparable]() *GSet[E] {
+	return &GSet[E]{
+		elements: make(map[E]struct{}),
+		delta:    make(map[E]struct{}),
+	}
+}
+
+func (s *GSet[E]) Add(element E) {
+	if _, ok := s.elements[element]; ok {
+		return
+	}
+
+	s.elements[element] = struct{}{}
+	s.delta[element] = struct{}{}
+}
+
+func (s *GSet[E]) Contains(element E) bool {
+	_, ok := s.elements[element]
+	return ok
+}
+
+func (s *GSet[E]) Elements() []E {
+	elements := make([]E, 0, len(s.elements))
+	for element := range s.elements {
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+func (s *GSet[E]) Merge(other *GSet[E]) {
+	for element := range other.elements {
+		s.elements[element] = struct{}{}
+	}
+}
+
+func (s *GSet[E]) Delta() (*GSet[E], bool) {
+	if len(s.delta) == 0 {
+		return nil, false
+	}
+
+	delta := &GSet[E]{elements: s.delta, delta: make(map[E]struct{})}
+	s.delta = make(map[E]struct{})
+	return delta, true
+}
+
+func (s *GSet[E]) Clone() *GSet[E] {
+	return &GSet[E]{elements: maps.Clone(s.elements), delta: make(map[E]struct{})}
+}
+
+func (s *GSet[E]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Elements())
+}
+
+func (s *GSet[E]) UnmarshalJSON(data []byte) error {
+	elements := make([]E, 0)
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+
+	s.elements = make(map[E]struct{}, len(elements))
+	s.delta = make(map[E]struct{})
+	for _, element := range elements {
+		s.elements[element] = struct{}{}
+	}
+	return nil
+}
+
+// ORSet is an add-wins observed-remove set. Every add is tagged uniquely and
+// a remove only tombstones the tags it has observed, so an add concurrent to
+// a remove survives. Tombstones are never collected.
+type ORSet[E comparable] struct {
+	tags         map[E]map[string]struct{}
+	removed      map[string]struct{}
+	sequence     int
+	deltaTags    map[E]map[string]struct{}
+	deltaRemoved map[string]struct{}
+}
+
+type orSetEntryJSON[E comparable] struct {
+	Element E        `json:"element"`
+	Tags    []string `json:"tags"`
+}
+
+type orSetJSON[E comparable] struct {
+	Adds    []orSetEntryJSON[E] `json:"adds"`
+	Removed []string            `json:"removed"`
+}
+
+func NewORSet[E comparable]() *ORSet[E] {
+	return &ORSet[E]{
+		tags:         make(map[E]map[string]struct{}),
+		removed:      make(map[string]struct{}),
+		deltaTags:    make(map[E]map[string]struct{}),
+		deltaRemoved: make(map[string]struct{}),
+	}
+}
+
+func addTag[E comparable](tags map[E]map[string]struct{}, element E, tag string) {
+	if _, ok := tags[element]; !ok {
+		tags[element] = make(map[string]struct{})
+	}
+	tags[element][tag] = struct{}{}
+}
+
+func (s *ORSet[E]) Add(node string, element E) {
+	s.sequence += 1
+	tag := node + ":" + strconv.Itoa(s.sequence)
+	addTag(s.tags, element, tag)
+	addTag(s.deltaTags, element, tag)
+}
+
+func (s *ORSet[E]) Remove(element E) {
+	for tag := range s.tags[element] {
+		s.removed[tag] = struct{}{}
+		s.deltaRemoved[tag] = struct{}{}
+	}
+}
+
+func (s *ORSet[E]) Contains(element E) bool {
+	for tag := range s.tags[element] {
+		if _, ok := s.removed[tag]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ORSet[E]) Elements() []E {
+	elements := make([]E, 0, len(s.tags))
+	for element := range s.tags {
+		if s.Contains(element) {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+func (s *ORSet[E]) Merge(other *ORSet[E]) {
+	for element, tags := range other.tags {
+		for tag := range tags {
+			addTag(s.tags, element, tag)
+		}
+	}
+	for tag := range other.removed {
+		s.removed[tag] = struct{}{}
+	}
+}
+
+func (s *ORSet[E]) Delta() (*ORSet[E], bool) {
+	if len(s.deltaTags) == 0 && len(s.deltaRemoved) == 0 {
+		return nil, false
+	}
+
+	delta := NewORSet[E]()
+	delta.tags, delta.removed = s.deltaTags, s.deltaRemoved
+	s.deltaTags = make(map[E]map[string]struct{})
+	s.deltaRemoved = make(map[string]struct{})
+	return delta, true
+}
+
+func (s *ORSet[E]) Clone() *ORSet[E] {
+	clone := NewORSet[E]()
+	clone.Merge(s)
+	clone.sequence = s.sequence
+	return clone
+}
+
+func (s *ORSet[E]) MarshalJSON() ([]byte, error) {
+	encoded := orSetJSON[E]{Adds: make([]orSetEntryJSON[E], 0, len(s.tags)), Removed: make([]string, 0, len(s.removed))}
+	for element, tags := range s.tags {
+		entry := orSetEntryJSON[E]{Element: element, Tags: make([]string, 0, len(tags))}
+		for tag := range tags {
+			entry.Tags = append(entry.Tags, tag)
+		}
+		encoded.Adds = append(encoded.Adds, entry)
+	}
+	for tag := range s.removed {
+		encoded.Removed = append(encoded.Removed, tag)
+	}
+	return json.Marshal(encoded)
+}
+
+func (s *ORSet[E]) UnmarshalJSON(data []byte) error {
+	decoded := orSetJSON[E]{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*s = *NewORSet[E]()
+	for _, entry := range decoded.Adds {
+		for _, tag := range entry.Tags {
+			addTag(s.tags, entry.Element, tag)
+		}
+	}
+	for _, tag := range decoded.Removed {
+		s.removed[tag] = struct{}{}
+	}
+	return nil
+}
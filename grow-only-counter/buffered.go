@@ -0,0 +1,143 @@
+package growonlycounter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// FLUSH_TIMEOUT bounds every flush, so a seq-kv request that never returns
+// doesn't hold up the flushes after it.
+const FLUSH_TIMEOUT time.Duration = time.Second
+
+// pendingDelta is the sum of the queued adds of a counter, each waiter gets
+// the outcome of the flush writing it.
+type pendingDelta struct {
+	delta   int
+	waiters []chan error
+}
+
+// BufferedCounterServer is the KV backed counter with adds aggregated locally
+// per counter and written with a single CAS every flush tick. An add is only
+// acknowledged once the batch holding it has been flushed to seq-kv. Batches
+// that definitely failed are flushed again, ones with an unknown outcome fail
+// their adds as timed out instead, as flushing them again could count them
+// twice.
+type BufferedCounterServer struct {
+	GrowOnlyCounterServer
+	lock              *sync.Mutex
+	pending           map[string]*pendingDelta
+	flushTickDuration time.Duration
+	flushTimeout      time.Duration
+}
+
+func NewBufferedCounterServer(n *maelstrom.Node, flushTickDuration time.Duration) BufferedCounterServer {
+	return NewBufferedCounterServerWithStore(n, maelstrom.NewSeqKV(n), kvstore.NewBarrierFreshness(n), flushTickDuration)
+}
+
+func NewBufferedCounterServerWithStore(n *maelstrom.Node, kv kvstore.Store, freshness kvstore.Freshness, flushTickDuration time.Duration) BufferedCounterServer {
+	return BufferedCounterServer{
+		GrowOnlyCounterServer: NewGrowOnlyCounterServerWithStore(n, kv, freshness),
+		lock:                  &sync.Mutex{},
+		pending:               make(map[string]*pendingDelta),
+		flushTickDuration:     flushTickDuration,
+		flushTimeout:          FLUSH_TIMEOUT,
+	}
+}
+
+func (s *BufferedCounterServer) Add(msg *AddMessage, ctx context.Context) (AddMessageReply, error) {
+	name := counterName(msg.Counter)
+	if err := s.register(name, ctx); err != nil {
+		return AddMessageReply{}, err
+	}
+
+	select {
+	case err := <-s.enqueue(name, msg.Delta):
+		if err != nil {
+			return AddMessageReply{}, err
+		}
+		return msg.Reply(), nil
+	case <-ctx.Done():
+		// the delta stays queued and may still be flushed, so the outcome is
+		// indefinite rather than a failure
+		return AddMessageReply{}, maelstrom.NewRPCError(maelstrom.Timeout, "add not flushed yet")
+	}
+}
+
+// enqueue adds delta to the pending total of name and returns the channel the
+// outcome of the flush writing it is sent on.
+func (s *BufferedCounterServer) enqueue(name string, delta int) <-chan error {
+	flushed := make(chan error, 1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pending, ok := s.pending[name]
+	if !ok {
+		pending = &pendingDelta{}
+		s.pending[name] = pending
+	}
+	pending.delta += delta
+	pending.waiters = append(pending.waiters, flushed)
+	return flushed
+}
+
+func (s *BufferedCounterServer) requeue(name string, failed *pendingDelta) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pending, ok := s.pending[name]
+	if !ok {
+		s.pending[name] = failed
+		return
+	}
+	pending.delta += failed.delta
+	pending.waiters = append(pending.waiters, failed.waiters...)
+}
+
+func (s *BufferedCounterServer) Flush(ctx context.Context) {
+	s.lock.Lock()
+	batch := s.pending
+	s.pending = make(map[string]*pendingDelta)
+	s.lock.Unlock()
+
+	for name, pending := range batch {
+		err := s.addToKey(counterKey(name), pending.delta, ctx)
+		if kvstore.IsRetryable(err) {
+			log.Printf("failed to flush delta %d of %s, requeueing: %v", pending.delta, name, err)
+			s.requeue(name, pending)
+			continue
+		}
+
+		if err != nil {
+			// the CAS may have been applied, flushing the delta again could
+			// count it twice
+			log.Printf("flush of delta %d of %s has an unknown outcome, dropping it: %v", pending.delta, name, err)
+			err = maelstrom.NewRPCError(maelstrom.Timeout, fmt.Sprintf("flush of %s has an unknown outcome: %v", name, err))
+		} else {
+			log.Printf("flushed delta %d for %d adds of %s", pending.delta, len(pending.waiters), name)
+		}
+		for _, flushed := range pending.waiters {
+			flushed <- err
+		}
+	}
+}
+
+func (s *BufferedCounterServer) FlushServer(ctx context.Context) {
+	flushTicker := time.NewTicker(s.flushTickDuration)
+	defer flushTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flushTicker.C:
+			flushCtx, cancel := context.WithTimeout(ctx, s.flushTimeout)
+			s.Flush(flushCtx)
+			cancel()
+		}
+	}
+}
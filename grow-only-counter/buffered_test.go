@@ -0,0 +1,136 @@
+package growonlycounter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestBufferedCounterAcknowledgesAfterFlush(t *testing.T) {
+	store := kvstore.NewMemoryStore()
+	n := maelstrom.NewNode()
+	counter := NewBufferedCounterServerWithStore(n, store, kvstore.NewBarrierFreshness(n), time.Hour)
+	ctx := context.TODO()
+
+	waiters := make([]<-chan error, 0, 3)
+	for _, delta := range []int{1, 2, 3} {
+		waiters = append(waiters, counter.enqueue(DEFAULT_COUNTER, delta))
+	}
+
+	for _, flushed := range waiters {
+		if done, _ := outcome(flushed); done {
+			t.Fatal("adds acknowledged before being flushed")
+		}
+	}
+
+	counter.Flush(ctx)
+
+	for _, flushed := range waiters {
+		if done, err := outcome(flushed); !done || err != nil {
+			t.Fatalf("expected adds to be acknowledged after flush but got %v", err)
+		}
+	}
+
+	if value, _ := store.ReadInt(ctx, GROW_ONLY_KEY); value != 6 {
+		t.Fatalf("expected flushed value 6 but was %d", value)
+	}
+}
+
+// failingCASStore fails every compare-and-swap with err, applying it first if
+// apply is set like a CAS whose reply was lost.
+type failingCASStore struct {
+	*kvstore.MemoryStore
+	err   error
+	apply bool
+}
+
+func (s *failingCASStore) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	if s.apply {
+		s.MemoryStore.CompareAndSwap(ctx, key, from, to, createIfNotExists)
+	}
+	return s.err
+}
+
+// outcome returns the outcome sent on flushed if the flush of its add is
+// over. Flush sends the outcomes before returning, so no waiting is needed.
+func outcome(flushed <-chan error) (bool, error) {
+	select {
+	case err := <-flushed:
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+// addAndFlush queues an add of delta and flushes it, returning the outcome of
+// the add, nil if it is still waiting for a later flush.
+func addAndFlush(counter *BufferedCounterServer, delta int) error {
+	flushed := counter.enqueue(DEFAULT_COUNTER, delta)
+	counter.Flush(context.TODO())
+	_, err := outcome(flushed)
+	return err
+}
+
+func TestBufferedCounterDropsFlushesWithUnknownOutcome(t *testing.T) {
+	store := &failingCASStore{MemoryStore: kvstore.NewMemoryStore(), err: maelstrom.NewRPCError(maelstrom.Timeout, "timed out"), apply: true}
+	n := maelstrom.NewNode()
+	counter := NewBufferedCounterServerWithStore(n, store, kvstore.NewBarrierFreshness(n), time.Hour)
+
+	if err := addAndFlush(&counter, 3); maelstrom.ErrorCode(err) != maelstrom.Timeout {
+		t.Errorf("expected the add to time out but got %v", err)
+	}
+
+	// the delta isn't flushed a second time
+	counter.Flush(context.TODO())
+	if value, _ := store.ReadInt(context.TODO(), GROW_ONLY_KEY); value != 3 {
+		t.Errorf("expected the delta to be counted once, 3, but was %d", value)
+	}
+}
+
+func TestBufferedCounterRequeuesDefiniteFailures(t *testing.T) {
+	store := &failingCASStore{MemoryStore: kvstore.NewMemoryStore(), err: maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "unavailable")}
+	n := maelstrom.NewNode()
+	counter := NewBufferedCounterServerWithStore(n, store, kvstore.NewBarrierFreshness(n), time.Hour)
+	counter.retryPolicy = kvstore.RetryPolicy{MaxAttempts: 1}
+
+	if err := addAndFlush(&counter, 3); err != nil {
+		t.Errorf("expected the add to wait for the next flush but got %v", err)
+	}
+
+	counter.lock.Lock()
+	pending, ok := counter.pending[DEFAULT_COUNTER]
+	counter.lock.Unlock()
+	if !ok || pending.delta != 3 || len(pending.waiters) != 1 {
+		t.Errorf("expected the delta to be requeued with its waiter but got %+v", pending)
+	}
+}
+
+// blockingCASStore holds every compare-and-swap until its context is done.
+type blockingCASStore struct {
+	*kvstore.MemoryStore
+}
+
+func (s blockingCASStore) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestFlushServerTimesOutFlushes(t *testing.T) {
+	n := maelstrom.NewNode()
+	counter := NewBufferedCounterServerWithStore(n, blockingCASStore{kvstore.NewMemoryStore()}, kvstore.NewBarrierFreshness(n), time.Millisecond)
+	counter.flushTimeout = time.Millisecond
+	counter.registered.Store(DEFAULT_COUNTER, struct{}{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go counter.FlushServer(ctx)
+
+	// the add is failed by the flush timing out, not by its own context
+	if _, err := counter.Add(&AddMessage{MessageType: "add", Delta: 3}, context.Background()); maelstrom.ErrorCode(err) != maelstrom.Timeout {
+		t.Errorf("expected the add to time out but got %v", err)
+	}
+}
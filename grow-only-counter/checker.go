@@ -0,0 +1,74 @@
+package growonlycounter
+
+import "fmt"
+
+// HistoryOperation is one client operation observed against a counter. Add
+// operations carry their delta in Value and reads the value they returned.
+// Invoke and Complete only need to be comparable, e.g. unix nanoseconds.
+// Final marks reads taken once every add completed and the nodes had time to
+// converge.
+type HistoryOperation struct {
+	Kind         string
+	Value        int
+	Invoke       int64
+	Complete     int64
+	Acknowledged bool
+	Final        bool
+}
+
+type BoundsViolation struct {
+	Read  HistoryOperation
+	Lower int
+	Upper int
+}
+
+func (v BoundsViolation) Error() string {
+	return fmt.Sprintf("read of %d at %d outside of [%d, %d]", v.Read.Value, v.Read.Invoke, v.Lower, v.Upper)
+}
+
+// ReadBounds returns the range a read must fall in. Any add invoked before the
+// read completed, acknowledged or not, may be visible. The counters are only
+// eventually consistent, a read may miss adds acknowledged by other nodes, so
+// acknowledged adds are only definitely visible to final reads.
+func ReadBounds(history []HistoryOperation, read HistoryOperation) (int, int) {
+	lower, upper := 0, 0
+	for _, op := range history {
+		if op.Kind != "add" || op.Invoke > read.Complete {
+			continue
+		}
+
+		definite := read.Final && op.Acknowledged && op.Complete < read.Invoke
+		switch {
+		case op.Value >= 0 && definite:
+			lower += op.Value
+			upper += op.Value
+		case op.Value >= 0:
+			upper += op.Value
+		case definite:
+			lower += op.Value
+			upper += op.Value
+		default:
+			lower += op.Value
+		}
+	}
+
+	return lower, upper
+}
+
+// CheckPNCounter validates that every acknowledged read of a PN-Counter lies
+// within the bounds implied by the adds around it.
+func CheckPNCounter(history []HistoryOperation) []BoundsViolation {
+	violations := make([]BoundsViolation, 0)
+	for _, op := range history {
+		if op.Kind != "read" || !op.Acknowledged {
+			continue
+		}
+
+		lower, upper := ReadBounds(history, op)
+		if op.Value < lower || op.Value > upper {
+			violations = append(violations, BoundsViolation{Read: op, Lower: lower, Upper: upper})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,72 @@
+package growonlycounter
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"gossip-glomers/crdt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// CRDTCounterServer is a delta-state G-Counter per counter name: every node
+// only increments its own slot and the deltas gossiped by peers are merged by
+// element-wise max, so reads are answered locally without touching seq-kv.
+type CRDTCounterServer struct {
+	n          *maelstrom.Node
+	replicator crdt.Replicator[*crdt.Map[*crdt.GCounter]]
+}
+
+func NewCRDTCounterServer(n *maelstrom.Node, gossipTickDuration time.Duration) CRDTCounterServer {
+	return CRDTCounterServer{
+		n: n,
+		replicator: crdt.NewReplicator(n, "counter_gossip", func() *crdt.Map[*crdt.GCounter] {
+			return crdt.NewMap(crdt.NewGCounter)
+		}, gossipTickDuration),
+	}
+}
+
+func (s *CRDTCounterServer) Read(msg *ReadMessage, ctx context.Context) (ReadMessageReply, error) {
+	value := 0
+	s.replicator.View(func(counters *crdt.Map[*crdt.GCounter]) {
+		if counter, ok := counters.Lookup(counterName(msg.Counter)); ok {
+			value = counter.Value()
+		}
+	})
+	return msg.Reply(value), nil
+}
+
+func (s *CRDTCounterServer) Add(msg *AddMessage, ctx context.Context) (AddMessageReply, error) {
+	if msg.Delta < 0 {
+		log.Printf("rejecting negative delta %d for grow-only counter", msg.Delta)
+		return AddMessageReply{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, "grow-only counter does not accept negative deltas")
+	}
+
+	s.replicator.Update(func(counters *crdt.Map[*crdt.GCounter]) {
+		counters.Get(counterName(msg.Counter)).Increment(s.n.ID(), msg.Delta)
+	})
+	return msg.Reply(), nil
+}
+
+func (s *CRDTCounterServer) ListCounters(msg *ListCountersMessage, ctx context.Context) (ListCountersMessageReply, error) {
+	names := make([]string, 0)
+	s.replicator.View(func(counters *crdt.Map[*crdt.GCounter]) {
+		names = counters.Keys()
+	})
+	slices.Sort(names)
+	return msg.Reply(names), nil
+}
+
+func (s *CRDTCounterServer) GossipMessageType() string {
+	return s.replicator.MessageType()
+}
+
+func (s *CRDTCounterServer) HandleGossip(msg maelstrom.Message) error {
+	return s.replicator.HandleGossip(msg)
+}
+
+func (s *CRDTCounterServer) Gossiper(ctx context.Context) {
+	s.replicator.Gossiper(ctx)
+}
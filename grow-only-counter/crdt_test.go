@@ -0,0 +1,94 @@
+package growonlycounter
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"gossip-glomers/crdt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func gossipFrom[T crdt.CRDT[T]](t *testing.T, replicator *crdt.Replicator[T], source string) maelstrom.Message {
+	var state []byte
+	var err error
+	replicator.View(func(value T) {
+		state, err = json.Marshal(value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(crdt.GossipMessage{MessageType: replicator.MessageType(), State: state, Full: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return maelstrom.Message{Src: source, Dest: "n0", Body: body}
+}
+
+func newCRDTCounter(id string) CRDTCounterServer {
+	n := maelstrom.NewNode()
+	n.Init(id, []string{"n0", "n1"})
+	return NewCRDTCounterServer(n, time.Second)
+}
+
+func TestCRDTCounterAddIsReadLocally(t *testing.T) {
+	counter := newCRDTCounter("n0")
+	ctx := context.TODO()
+
+	counter.Add(&AddMessage{MessageType: "add", Delta: 3}, ctx)
+	counter.Add(&AddMessage{MessageType: "add", Delta: 4}, ctx)
+	reply, _ := counter.Read(&ReadMessage{MessageType: "read"}, ctx)
+
+	if reply.MessageType != "read_ok" || reply.Value != 7 {
+		t.Fatalf("expected read_ok with 7 but got %v", reply)
+	}
+}
+
+func TestCRDTCounterGossipMergesByMax(t *testing.T) {
+	first := newCRDTCounter("n0")
+	second := newCRDTCounter("n1")
+	ctx := context.TODO()
+
+	first.Add(&AddMessage{MessageType: "add", Delta: 5}, ctx)
+	second.Add(&AddMessage{MessageType: "add", Delta: 1}, ctx)
+	stale := gossipFrom(t, &second.replicator, "n1")
+	second.Add(&AddMessage{MessageType: "add", Delta: 1}, ctx)
+
+	first.HandleGossip(gossipFrom(t, &second.replicator, "n1"))
+	second.HandleGossip(gossipFrom(t, &first.replicator, "n0"))
+	// stale and duplicated gossip must not lower or double count
+	first.HandleGossip(stale)
+	second.HandleGossip(gossipFrom(t, &first.replicator, "n0"))
+
+	for _, counter := range []CRDTCounterServer{first, second} {
+		if reply, _ := counter.Read(&ReadMessage{MessageType: "read"}, ctx); reply.Value != 7 {
+			t.Errorf("%s expected 7 but read %d", counter.n.ID(), reply.Value)
+		}
+	}
+}
+
+func TestCRDTNamedCounters(t *testing.T) {
+	counter := newCRDTCounter("n0")
+	ctx := context.TODO()
+
+	counter.Add(&AddMessage{MessageType: "add", Delta: 2, Counter: "tenant-a"}, ctx)
+	counter.Add(&AddMessage{MessageType: "add", Delta: 9, Counter: "tenant-b"}, ctx)
+	counter.Add(&AddMessage{MessageType: "add", Delta: 1}, ctx)
+
+	if reply, _ := counter.Read(&ReadMessage{MessageType: "read", Counter: "tenant-a"}, ctx); reply.Value != 2 || reply.Counter != "tenant-a" {
+		t.Errorf("expected tenant-a to be 2 but got %v", reply)
+	}
+
+	if reply, _ := counter.Read(&ReadMessage{MessageType: "read"}, ctx); reply.Value != 1 {
+		t.Errorf("expected default counter to be 1 but read %d", reply.Value)
+	}
+
+	reply, _ := counter.ListCounters(&ListCountersMessage{MessageType: "list_counters"}, ctx)
+	if reply.MessageType != "list_counters_ok" || !slices.Equal(reply.Counters, []string{DEFAULT_COUNTER, "tenant-a", "tenant-b"}) {
+		t.Errorf("unexpected list_counters reply %v", reply)
+	}
+}
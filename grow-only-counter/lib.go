@@ -3,54 +3,150 @@ package growonlycounter
 import (
 	"context"
 	"log"
-	"math/rand"
 	"os"
-	"strconv"
+	"slices"
+	"sync"
+
+	kvstore "gossip-glomers/kv-store"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
-const GROW_ONLY_KEY string = "groww"
+const (
+	GROW_ONLY_KEY   string = "groww"
+	COUNTERS_KEY    string = "groww-counters"
+	DEFAULT_COUNTER string = "default"
+)
+
+type Counter interface {
+	Read(msg *ReadMessage, ctx context.Context) (ReadMessageReply, error)
+	Add(msg *AddMessage, ctx context.Context) (AddMessageReply, error)
+	ListCounters(msg *ListCountersMessage, ctx context.Context) (ListCountersMessageReply, error)
+}
+
+func counterName(name string) string {
+	if name == "" {
+		return DEFAULT_COUNTER
+	}
+	return name
+}
+
+// counterKey keeps the default counter under GROW_ONLY_KEY so deployments
+// that never name a counter read the same key as before.
+func counterKey(name string) string {
+	if name == DEFAULT_COUNTER {
+		return GROW_ONLY_KEY
+	}
+	return GROW_ONLY_KEY + "/" + name
+}
 
 type GrowOnlyCounterServer struct {
-	n  *maelstrom.Node
-	kv *maelstrom.KV
+	n           *maelstrom.Node
+	kv          kvstore.Store
+	registered  *sync.Map
+	retryPolicy kvstore.RetryPolicy
+	freshness   kvstore.Freshness
 }
 
 func NewGrowOnlyCounterServer(n *maelstrom.Node) GrowOnlyCounterServer {
+	return NewGrowOnlyCounterServerWithStore(n, maelstrom.NewSeqKV(n), kvstore.NewBarrierFreshness(n))
+}
+
+func NewGrowOnlyCounterServerWithStore(n *maelstrom.Node, kv kvstore.Store, freshness kvstore.Freshness) GrowOnlyCounterServer {
 	log.SetOutput(os.Stderr)
-	kv := maelstrom.NewSeqKV(n)
 	return GrowOnlyCounterServer{
-		n:  n,
-		kv: kv,
+		n:           n,
+		kv:          kv,
+		registered:  &sync.Map{},
+		retryPolicy: kvstore.DefaultRetryPolicy,
+		freshness:   freshness,
 	}
 }
 
-func (s *GrowOnlyCounterServer) Read(msg *ReadMessage, ctx context.Context) ReadMessageReply {
-	s.kv.Write(ctx, strconv.Itoa(int(rand.Int63())), nil)
-	value, err := s.kv.ReadInt(ctx, GROW_ONLY_KEY)
+// Read replies with the value of the counter, a counter that never received
+// an add reads as 0.
+func (s *GrowOnlyCounterServer) Read(msg *ReadMessage, ctx context.Context) (ReadMessageReply, error) {
+	key := counterKey(counterName(msg.Counter))
+	value, err := kvstore.ReadInt(ctx, s.freshness, s.kv, key)
+	if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+		return msg.Reply(0), nil
+	}
 	if err != nil {
-		log.Printf("failed to read %s from sequential KV\n", GROW_ONLY_KEY)
-		return ReadMessageReply{}
+		log.Printf("failed to read %s from sequential KV: %v", key, err)
+		return ReadMessageReply{}, kvstore.Unavailable(err)
+	}
+	return msg.Reply(value), nil
+}
+
+func (s *GrowOnlyCounterServer) Add(msg *AddMessage, ctx context.Context) (AddMessageReply, error) {
+	name := counterName(msg.Counter)
+	if err := s.register(name, ctx); err != nil {
+		return AddMessageReply{}, err
 	}
-	return msg.Reply(value)
+
+	if err := s.addToKey(counterKey(name), msg.Delta, ctx); err != nil {
+		return AddMessageReply{}, err
+	}
+	return msg.Reply(), nil
 }
 
-func (s *GrowOnlyCounterServer) Add(msg *AddMessage, ctx context.Context) AddMessageReply {
-	for {
-		value, err := s.kv.ReadInt(ctx, GROW_ONLY_KEY)
+func (s *GrowOnlyCounterServer) addToKey(key string, delta int, ctx context.Context) error {
+	return kvstore.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+		value, err := s.kv.ReadInt(ctx, key)
 		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
-			log.Printf("failed to read %s from sequential KV", GROW_ONLY_KEY)
-			continue
+			log.Printf("failed to read %s from sequential KV", key)
+			return kvstore.Unavailable(err)
 		}
 
 		log.Printf("read value %d\n", value)
-		err = s.kv.CompareAndSwap(ctx, GROW_ONLY_KEY, value, value+msg.Delta, true)
+		err = s.kv.CompareAndSwap(ctx, key, value, value+delta, true)
 		if err == nil {
-			log.Printf("value updated to %d\n", value+msg.Delta)
-			return msg.Reply()
+			log.Printf("value of %s updated to %d\n", key, value+delta)
+			s.freshness.Observe(key, value+delta)
+		}
+		return err
+	})
+}
+
+// register records the counter name under COUNTERS_KEY the first time this
+// node sees an add for it, so list_counters can be served from a single key.
+func (s *GrowOnlyCounterServer) register(name string, ctx context.Context) error {
+	if _, ok := s.registered.Load(name); ok {
+		return nil
+	}
+
+	err := kvstore.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+		names := make([]string, 0)
+		err := s.kv.ReadInto(ctx, COUNTERS_KEY, &names)
+		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			log.Printf("failed to read %s from sequential KV: %v", COUNTERS_KEY, err)
+			return kvstore.Unavailable(err)
 		}
 
-		log.Printf("retrying with delta %d\n", msg.Delta)
+		if slices.Contains(names, name) {
+			return nil
+		}
+
+		return s.kv.CompareAndSwap(ctx, COUNTERS_KEY, names, append(names, name), true)
+	})
+	if err != nil {
+		log.Printf("failed to register counter %s: %v", name, err)
+		return err
+	}
+
+	log.Printf("registered counter %s", name)
+	s.registered.Store(name, struct{}{})
+	return nil
+}
+
+func (s *GrowOnlyCounterServer) ListCounters(msg *ListCountersMessage, ctx context.Context) (ListCountersMessageReply, error) {
+	names := make([]string, 0)
+	err := kvstore.ReadInto(ctx, s.freshness, s.kv, COUNTERS_KEY, &names)
+	if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		log.Printf("failed to read %s from sequential KV: %v", COUNTERS_KEY, err)
+		return ListCountersMessageReply{}, kvstore.Unavailable(err)
 	}
+
+	slices.Sort(names)
+	return msg.Reply(names), nil
 }
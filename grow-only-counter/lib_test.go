@@ -0,0 +1,104 @@
+package growonlycounter
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestGrowOnlyCounterNamedCounters(t *testing.T) {
+	store := kvstore.NewMemoryStore()
+	n := maelstrom.NewNode()
+	counter := NewGrowOnlyCounterServerWithStore(n, store, kvstore.NewBarrierFreshness(n))
+	ctx := context.TODO()
+
+	counter.Add(&AddMessage{MessageType: "add", Delta: 3}, ctx)
+	counter.Add(&AddMessage{MessageType: "add", Delta: 5, Counter: "requests"}, ctx)
+	counter.Add(&AddMessage{MessageType: "add", Delta: 1, Counter: "requests"}, ctx)
+
+	if value, _ := store.ReadInt(ctx, GROW_ONLY_KEY); value != 3 {
+		t.Errorf("default counter should stay under %s but was %d", GROW_ONLY_KEY, value)
+	}
+
+	if reply, _ := counter.Read(&ReadMessage{MessageType: "read", Counter: "requests"}, ctx); reply.Value != 6 {
+		t.Errorf("expected requests to be 6 but read %d", reply.Value)
+	}
+
+	reply, _ := counter.ListCounters(&ListCountersMessage{MessageType: "list_counters"}, ctx)
+	if !slices.Equal(reply.Counters, []string{DEFAULT_COUNTER, "requests"}) {
+		t.Errorf("unexpected counters %v", reply.Counters)
+	}
+}
+
+func TestGrowOnlyCounterReadsFreshFromStaleStore(t *testing.T) {
+	store := kvstore.NewStaleStore()
+	ctx := context.TODO()
+	first, second := maelstrom.NewNode(), maelstrom.NewNode()
+	first.Init("n0", []string{"n0", "n1"})
+	second.Init("n1", []string{"n0", "n1"})
+	writer := NewGrowOnlyCounterServerWithStore(first, store.Client(), kvstore.NewBarrierFreshness(first))
+	reader := NewGrowOnlyCounterServerWithStore(second, store.Client(), kvstore.NewBarrierFreshness(second))
+
+	for range 3 {
+		if _, err := writer.Add(&AddMessage{MessageType: "add", Delta: 2}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reply, _ := reader.Read(&ReadMessage{MessageType: "read"}, ctx); reply.Value != 6 {
+		t.Fatalf("expected a fresh read of 6 but read %d", reply.Value)
+	}
+}
+
+func TestGrowOnlyCounterSessionReadsOwnWrites(t *testing.T) {
+	store := kvstore.NewStaleStore()
+	ctx := context.TODO()
+	freshness := kvstore.NewSessionFreshness(kvstore.MAX_FRESHNESS_READS)
+	writer := NewGrowOnlyCounterServerWithStore(maelstrom.NewNode(), store.Client(), freshness)
+	// a second client sharing the node's session, e.g. a fresh connection
+	reader := NewGrowOnlyCounterServerWithStore(maelstrom.NewNode(), store.Client(), freshness)
+
+	for range 3 {
+		if _, err := writer.Add(&AddMessage{MessageType: "add", Delta: 1}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reply, _ := reader.Read(&ReadMessage{MessageType: "read"}, ctx); reply.Value != 3 {
+		t.Fatalf("expected to read own writes of 3 but read %d", reply.Value)
+	}
+}
+
+// failingReadStore fails every read with err.
+type failingReadStore struct {
+	*kvstore.MemoryStore
+	err error
+}
+
+func (s *failingReadStore) Read(ctx context.Context, key string) (any, error) {
+	return nil, s.err
+}
+
+func TestGrowOnlyCounterReadErrors(t *testing.T) {
+	n := maelstrom.NewNode()
+	ctx := context.TODO()
+
+	// a counter that never received an add reads as 0
+	counter := NewGrowOnlyCounterServerWithStore(n, kvstore.NewMemoryStore(), kvstore.NewBarrierFreshness(n))
+	if reply, err := counter.Read(&ReadMessage{MessageType: "read", Counter: "unknown"}, ctx); err != nil || reply.MessageType != "read_ok" || reply.Value != 0 {
+		t.Errorf("expected read_ok with 0 but got %v, %v", reply, err)
+	}
+
+	store := &failingReadStore{MemoryStore: kvstore.NewMemoryStore(), err: maelstrom.NewRPCError(maelstrom.Crash, "crashed")}
+	counter = NewGrowOnlyCounterServerWithStore(n, store, kvstore.NewBarrierFreshness(n))
+	if _, err := counter.Read(&ReadMessage{MessageType: "read"}, ctx); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected the read to be unavailable but got %v", err)
+	}
+	if _, err := counter.ListCounters(&ListCountersMessage{MessageType: "list_counters"}, ctx); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected listing counters to be unavailable but got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package growonlycounter
 type AddMessage struct {
 	MessageType string `json:"type"`
 	Delta       int    `json:"delta"`
+	Counter     string `json:"counter,omitempty"`
 }
 
 func (m *AddMessage) Reply() AddMessageReply {
@@ -15,13 +16,28 @@ type AddMessageReply struct {
 
 type ReadMessage struct {
 	MessageType string `json:"type"`
+	Counter     string `json:"counter,omitempty"`
 }
 
 type ReadMessageReply struct {
 	MessageType string `json:"type"`
 	Value       int    `json:"value"`
+	Counter     string `json:"counter,omitempty"`
 }
 
 func (m *ReadMessage) Reply(value int) ReadMessageReply {
-	return ReadMessageReply{MessageType: "read_ok", Value: value}
+	return ReadMessageReply{MessageType: "read_ok", Value: value, Counter: m.Counter}
+}
+
+type ListCountersMessage struct {
+	MessageType string `json:"type"`
+}
+
+type ListCountersMessageReply struct {
+	MessageType string   `json:"type"`
+	Counters    []string `json:"counters"`
+}
+
+func (m *ListCountersMessage) Reply(counters []string) ListCountersMessageReply {
+	return ListCountersMessageReply{MessageType: "list_counters_ok", Counters: counters}
 }
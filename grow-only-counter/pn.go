@@ -0,0 +1,66 @@
+package growonlycounter
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"gossip-glomers/crdt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// PNCounterServer is a PN-Counter per counter name, built from two G-Counter
+// vectors, one for increments and one for decrements, so negative deltas
+// merge as safely as positive ones.
+type PNCounterServer struct {
+	n          *maelstrom.Node
+	replicator crdt.Replicator[*crdt.Map[*crdt.PNCounter]]
+}
+
+func NewPNCounterServer(n *maelstrom.Node, gossipTickDuration time.Duration) PNCounterServer {
+	return PNCounterServer{
+		n: n,
+		replicator: crdt.NewReplicator(n, "pn_counter_gossip", func() *crdt.Map[*crdt.PNCounter] {
+			return crdt.NewMap(crdt.NewPNCounter)
+		}, gossipTickDuration),
+	}
+}
+
+func (s *PNCounterServer) Read(msg *ReadMessage, ctx context.Context) (ReadMessageReply, error) {
+	value := 0
+	s.replicator.View(func(counters *crdt.Map[*crdt.PNCounter]) {
+		if counter, ok := counters.Lookup(counterName(msg.Counter)); ok {
+			value = counter.Value()
+		}
+	})
+	return msg.Reply(value), nil
+}
+
+func (s *PNCounterServer) Add(msg *AddMessage, ctx context.Context) (AddMessageReply, error) {
+	s.replicator.Update(func(counters *crdt.Map[*crdt.PNCounter]) {
+		counters.Get(counterName(msg.Counter)).Increment(s.n.ID(), msg.Delta)
+	})
+	return msg.Reply(), nil
+}
+
+func (s *PNCounterServer) ListCounters(msg *ListCountersMessage, ctx context.Context) (ListCountersMessageReply, error) {
+	names := make([]string, 0)
+	s.replicator.View(func(counters *crdt.Map[*crdt.PNCounter]) {
+		names = counters.Keys()
+	})
+	slices.Sort(names)
+	return msg.Reply(names), nil
+}
+
+func (s *PNCounterServer) GossipMessageType() string {
+	return s.replicator.MessageType()
+}
+
+func (s *PNCounterServer) HandleGossip(msg maelstrom.Message) error {
+	return s.replicator.HandleGossip(msg)
+}
+
+func (s *PNCounterServer) Gossiper(ctx context.Context) {
+	s.replicator.Gossiper(ctx)
+}
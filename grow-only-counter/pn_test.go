@@ -0,0 +1,93 @@
+package growonlycounter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newPNCounter(id string) PNCounterServer {
+	n := maelstrom.NewNode()
+	n.Init(id, []string{"n0", "n1"})
+	return NewPNCounterServer(n, time.Second)
+}
+
+func TestPNCounterNegativeDelta(t *testing.T) {
+	counter := newPNCounter("n0")
+	ctx := context.TODO()
+
+	counter.Add(&AddMessage{MessageType: "add", Delta: 10}, ctx)
+	counter.Add(&AddMessage{MessageType: "add", Delta: -4}, ctx)
+	counter.Add(&AddMessage{MessageType: "add", Delta: -8}, ctx)
+
+	if reply, _ := counter.Read(&ReadMessage{MessageType: "read"}, ctx); reply.Value != -2 {
+		t.Fatalf("expected -2 but read %d", reply.Value)
+	}
+}
+
+func TestPNCounterGossipConverges(t *testing.T) {
+	first := newPNCounter("n0")
+	second := newPNCounter("n1")
+	ctx := context.TODO()
+
+	first.Add(&AddMessage{MessageType: "add", Delta: 6}, ctx)
+	second.Add(&AddMessage{MessageType: "add", Delta: -3}, ctx)
+
+	first.HandleGossip(gossipFrom(t, &second.replicator, "n1"))
+	second.HandleGossip(gossipFrom(t, &first.replicator, "n0"))
+	second.HandleGossip(gossipFrom(t, &first.replicator, "n0"))
+
+	for _, counter := range []PNCounterServer{first, second} {
+		if reply, _ := counter.Read(&ReadMessage{MessageType: "read"}, ctx); reply.Value != 3 {
+			t.Errorf("%s expected 3 but read %d", counter.n.ID(), reply.Value)
+		}
+	}
+}
+
+func TestCheckPNCounterWithinBounds(t *testing.T) {
+	history := []HistoryOperation{
+		{Kind: "add", Value: 5, Invoke: 0, Complete: 1, Acknowledged: true},
+		{Kind: "add", Value: -2, Invoke: 2, Complete: 6, Acknowledged: true},
+		{Kind: "add", Value: 4, Invoke: 3, Complete: 4, Acknowledged: false},
+		{Kind: "read", Value: 3, Invoke: 4, Complete: 5, Acknowledged: true},
+		{Kind: "read", Value: 7, Invoke: 5, Complete: 6, Acknowledged: true},
+		{Kind: "read", Value: 7, Invoke: 7, Complete: 8, Acknowledged: true, Final: true},
+	}
+
+	if violations := CheckPNCounter(history); len(violations) != 0 {
+		t.Fatalf("unexpected violations %v", violations)
+	}
+}
+
+func TestCheckPNCounterOutsideBounds(t *testing.T) {
+	history := []HistoryOperation{
+		{Kind: "add", Value: 5, Invoke: 0, Complete: 1, Acknowledged: true},
+		{Kind: "add", Value: -2, Invoke: 2, Complete: 3, Acknowledged: true},
+		{Kind: "read", Value: 5, Invoke: 4, Complete: 5, Acknowledged: true, Final: true},
+		{Kind: "read", Value: 1, Invoke: 4, Complete: 5, Acknowledged: false, Final: true},
+	}
+
+	violations := CheckPNCounter(history)
+
+	if len(violations) != 1 || violations[0].Lower != 3 || violations[0].Upper != 3 {
+		t.Fatalf("expected a single violation with bounds [3, 3] but got %v", violations)
+	}
+}
+
+func TestCheckPNCounterReadsMissingAcknowledgedAdds(t *testing.T) {
+	history := []HistoryOperation{
+		{Kind: "add", Value: 5, Invoke: 0, Complete: 1, Acknowledged: true},
+		{Kind: "add", Value: -2, Invoke: 2, Complete: 3, Acknowledged: true},
+		// a node that didn't hear of either add yet
+		{Kind: "read", Value: 0, Invoke: 4, Complete: 5, Acknowledged: true},
+		{Kind: "read", Value: 0, Invoke: 6, Complete: 7, Acknowledged: true, Final: true},
+	}
+
+	violations := CheckPNCounter(history)
+
+	if len(violations) != 1 || violations[0].Read.Invoke != 6 {
+		t.Fatalf("expected only the final read to be a violation but got %v", violations)
+	}
+}
@@ -0,0 +1,291 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// ListKeys lists the keys known to any node with the log offsets of their
+// leader or most up to date replica and the offsets committed for them.
+// Nodes that don't answer are left out.
+func (s *KafkaSever) ListKeys(msg *ListKeysMessage, ctx context.Context) ListKeysMessageReply {
+	keys := s.localKeys()
+	if msg.Local {
+		return msg.Reply(keys)
+	}
+
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, node := range s.node.NodeIDs() {
+		if node == s.node.ID() {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, err := forwardTo[ListKeysMessageReply](ctx, s.node, node, &ListKeysMessage{MessageType: "list_keys", Local: true})
+			if err != nil {
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			for key, info := range reply.Keys {
+				if known, ok := keys[key]; !ok || info.fresherThan(known) {
+					keys[key] = info
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	committed := s.ListCommitedOffsets(&ListCommittedOffsets{MessageType: "list_committed_offsets", Keys: slices.Sorted(maps.Keys(keys)), Group: msg.Group}, ctx)
+	for key, offset := range committed.Offsets {
+		info := keys[key]
+		info.Committed = &offset
+		keys[key] = info
+	}
+
+	return msg.Reply(keys)
+}
+
+// fresherThan prefers the leader's view of a key over a replica's and
+// otherwise the longer log.
+func (i KeyInfo) fresherThan(other KeyInfo) bool {
+	if (i.Leader != "") != (other.Leader != "") {
+		return i.Leader != ""
+	}
+	return i.LogEnd > other.LogEnd
+}
+
+func (s *KafkaSever) localKeys() map[string]KeyInfo {
+	s.lock.RLock()
+	partitions := maps.Clone(s.partitions)
+	s.lock.RUnlock()
+
+	keys := make(map[string]KeyInfo)
+	for key, p := range partitions {
+		p.lock.Lock()
+		if p.log.end > 0 {
+			info := KeyInfo{LogStart: p.log.start, LogEnd: p.log.end, HighWatermark: p.highWatermark}
+			if s.leads(key, p) {
+				info.Leader = s.node.ID()
+			}
+			keys[key] = info
+		}
+		p.lock.Unlock()
+	}
+
+	return keys
+}
+
+// DescribeKey reports who leads a key, its replicas and its log offsets. It
+// is answered by the leader, the only node knowing the in-sync replicas, and
+// by the node asked if there is no leader to forward to.
+func (s *KafkaSever) DescribeKey(msg *DescribeKeyMessage, ctx context.Context) (DescribeKeyMessageReply, error) {
+	replicas := s.replicas(msg.Key)
+	l := s.currentLease(ctx, msg.Key)
+	leader := replicas[0]
+	if l.valid(time.Now()) {
+		leader = l.Leader
+	}
+
+	if leader != s.node.ID() && !msg.Forwarded {
+		forwarded := *msg
+		forwarded.Forwarded = true
+		return forwardTo[DescribeKeyMessageReply](ctx, s.node, leader, &forwarded)
+	}
+
+	reply := DescribeKeyMessageReply{MessageType: "describe_key_ok", Key: msg.Key, Leader: leader, Epoch: l.Epoch, Replicas: replicas}
+	if p, ok := s.partition(msg.Key, false); ok {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.log.end > 0 {
+			reply.LogStart, reply.LogEnd, reply.HighWatermark = p.log.start, p.log.end, p.highWatermark
+			if s.leads(msg.Key, p) {
+				reply.InSync = append([]string{s.node.ID()}, slices.Sorted(maps.Keys(p.inSync))...)
+			}
+			return reply, nil
+		}
+	}
+
+	stored, _, err := s.segments.Read(ctx, msg.Key, 0)
+	if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+		return DescribeKeyMessageReply{}, err
+	}
+	if err != nil {
+		log.Printf("failed to read %s to describe it: %v", msg.Key, err)
+		return DescribeKeyMessageReply{}, kvstore.Unavailable(err)
+	}
+
+	reply.LogStart, reply.LogEnd, reply.HighWatermark = stored.start, stored.end, stored.end
+	return reply, nil
+}
+
+// TruncateKey drops the records of a key at or after an offset. Records
+// removed by compaction before it may end the log earlier, the reply holds
+// the new log end.
+func (s *KafkaSever) TruncateKey(msg *TruncateKeyMessage, ctx context.Context) (TruncateKeyMessageReply, error) {
+	l, err := s.leaderFor(ctx, msg.Key)
+	if err != nil {
+		return TruncateKeyMessageReply{}, err
+	}
+
+	if l.Leader != s.node.ID() || !l.valid(time.Now()) {
+		return forwardToLeader[TruncateKeyMessageReply](ctx, s, msg.Key, l, msg)
+	}
+
+	p, err := s.leadPartition(ctx, msg.Key, l.Epoch)
+	if err != nil {
+		return TruncateKeyMessageReply{}, err
+	}
+	defer p.lock.Unlock()
+
+	end, err := s.truncateLog(ctx, msg.Key, p, l.Epoch, msg.Offset)
+	if err != nil {
+		return TruncateKeyMessageReply{}, err
+	}
+
+	return msg.Reply(end), nil
+}
+
+// DeleteKey removes every record of a key. Offsets keep growing from the old
+// log end, which becomes the log start, so committed offsets stay valid.
+func (s *KafkaSever) DeleteKey(msg *DeleteKeyMessage, ctx context.Context) (DeleteKeyMessageReply, error) {
+	l, err := s.leaderFor(ctx, msg.Key)
+	if err != nil {
+		return DeleteKeyMessageReply{}, err
+	}
+
+	if l.Leader != s.node.ID() || !l.valid(time.Now()) {
+		return forwardToLeader[DeleteKeyMessageReply](ctx, s, msg.Key, l, msg)
+	}
+
+	p, err := s.leadPartition(ctx, msg.Key, l.Epoch)
+	if err != nil {
+		return DeleteKeyMessageReply{}, err
+	}
+	defer p.lock.Unlock()
+
+	start, err := s.deleteLog(ctx, msg.Key, p, l.Epoch)
+	if err != nil {
+		return DeleteKeyMessageReply{}, err
+	}
+
+	return msg.Reply(start), nil
+}
+
+// leadPartition returns the partition of key locked for the leader at epoch
+// with its log reloaded from the storage, admin requests change the stored log
+// and can't rely on the cache being current.
+func (s *KafkaSever) leadPartition(ctx context.Context, key string, epoch int) (*partition, error) {
+	p, _ := s.partition(key, true)
+	p.lock.Lock()
+	if err := s.fenced(key, p, epoch); err != nil {
+		p.lock.Unlock()
+		return nil, err
+	}
+	p.lead(epoch, s.followers(key))
+
+	if err := s.reload(ctx, key, p); err != nil {
+		p.lock.Unlock()
+		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+			return nil, err
+		}
+		return nil, kvstore.Unavailable(err)
+	}
+
+	if err := s.fenced(key, p, epoch); err != nil {
+		p.leader = false
+		p.lock.Unlock()
+		return nil, err
+	}
+	return p, nil
+}
+
+// truncateLog drops the records of key at or after offset, first from the
+// storage and then from the cache, and hands the shorter log to the
+// followers. The caller holds p.lock as the leader at epoch.
+func (s *KafkaSever) truncateLog(ctx context.Context, key string, p *partition, epoch int, offset int) (int, error) {
+	end, err := s.cutLog(ctx, key, p, epoch, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.replicate(ctx, key, p); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+// cutLog drops the records of key at or after offset from the storage and
+// the cache and returns the new log end, which compaction may have left past
+// offset. Followers drop them with the next replicate. The caller holds
+// p.lock as the leader at epoch.
+func (s *KafkaSever) cutLog(ctx context.Context, key string, p *partition, epoch int, offset int) (int, error) {
+	if offset >= p.log.end {
+		return p.log.end, nil
+	}
+
+	tail := s.segments.segmentStart(max(offset, p.log.start))
+	kept := p.log.upTo(offset).from(tail)
+	end := max(tail, p.log.start)
+	if len(kept) > 0 {
+		end = max(end, kept[len(kept)-1].Offset+1)
+	}
+
+	if err := s.segments.Truncate(ctx, key, p.log, end, epoch); err != nil {
+		return 0, kvstore.Unavailable(err)
+	}
+
+	log.Printf("truncated %s from %d to %d", key, p.log.end, end)
+	p.log.truncate(end)
+	p.highWatermark = min(p.highWatermark, end)
+	p.compactedTo = min(p.compactedTo, tail)
+	p.rebuildProducers()
+	p.storedEpoch = epoch
+	for follower, matched := range p.matched {
+		p.matched[follower] = min(matched, end)
+	}
+	return end, nil
+}
+
+// deleteLog empties the log of key by moving its log start to its end, first
+// in the storage and then in the cache, and hands the log start to the
+// followers. The caller holds p.lock as the leader at epoch.
+func (s *KafkaSever) deleteLog(ctx context.Context, key string, p *partition, epoch int) (int, error) {
+	start := p.log.end
+	if err := s.segments.TrimHead(ctx, key, p.log, start, epoch); err != nil {
+		return 0, kvstore.Unavailable(err)
+	}
+
+	log.Printf("deleted %s up to %d", key, start)
+	p.log.trimHead(start)
+	p.rebuildProducers()
+	p.storedEpoch = epoch
+
+	if err := s.replicate(ctx, key, p); err != nil {
+		return 0, err
+	}
+	return start, nil
+}
+
+// forwardToLeader hands an admin request for key to the node its lease l
+// routes to, like sends.
+func forwardToLeader[T any](ctx context.Context, s *KafkaSever, key string, l lease, msg any) (T, error) {
+	target := s.route(key, l)
+	reply, err := forwardTo[T](ctx, s.node, target, msg)
+	if err != nil {
+		return reply, s.forwardFailed(key, l, target, err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// newAdminTestServers returns a leader with 5 records of "luck" and a reader
+// polling the leader's log from lin-kv, both with segments of 2 records.
+func newAdminTestServers(t *testing.T) (*KafkaSever, *KafkaSever) {
+	linKV := kvstore.NewMemoryStore()
+	leader := newTestKafkaServer(kvstore.NewMemoryStore())
+	leader.linKV, leader.segments = linKV, newSegmentStore(linKV, 2)
+	for value := range 5 {
+		if _, err := leader.Send(&SendMessage{MessageType: "send", Key: "luck", Value: value}, context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return leader, newStoreReader(leader)
+}
+
+// newStoreReader returns a server reading the stored log of leader, as leader
+// would after a restart.
+func newStoreReader(leader *KafkaSever) *KafkaSever {
+	reader := newTestKafkaServer(kvstore.NewMemoryStore())
+	reader.linKV, reader.segments = leader.linKV, leader.segments
+	return reader
+}
+
+func TestTruncateKey(t *testing.T) {
+	leader, reader := newAdminTestServers(t)
+	ctx := context.TODO()
+
+	reply, err := leader.TruncateKey(&TruncateKeyMessage{MessageType: "truncate_key", Key: "luck", Offset: 3}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.LogEnd != 3 {
+		t.Errorf("expected log end 3 but got %d", reply.LogEnd)
+	}
+
+	expected := []Message{NewMessage(0, 0), NewMessage(1, 1), NewMessage(2, 2)}
+	if messages, _ := replicatedMessages(leader, "luck"); !slices.Equal(messages, expected) {
+		t.Errorf("expected cached log %v but was %v", expected, messages)
+	}
+
+	polled, _ := reader.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}}, ctx)
+	if !slices.Equal(polled.Messages["luck"], expected) {
+		t.Errorf("expected stored log %v but was %v", expected, polled.Messages["luck"])
+	}
+
+	sent, err := leader.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 30}, ctx)
+	if err != nil || sent.Offset != 3 {
+		t.Errorf("expected the next send at offset 3 but got %d: %v", sent.Offset, err)
+	}
+}
+
+func TestDeleteKey(t *testing.T) {
+	leader, reader := newAdminTestServers(t)
+	ctx := context.TODO()
+
+	reply, err := leader.DeleteKey(&DeleteKeyMessage{MessageType: "delete_key", Key: "luck"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.LogStart != 5 {
+		t.Errorf("expected log start 5 but got %d", reply.LogStart)
+	}
+
+	polled, _ := reader.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}}, ctx)
+	if len(polled.Messages["luck"]) != 0 || polled.LogStartOffsets["luck"] != 5 {
+		t.Errorf("expected no records from log start 5 but polled %v from %v", polled.Messages, polled.LogStartOffsets)
+	}
+
+	// offsets keep growing
+	sent, err := leader.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 50}, ctx)
+	if err != nil || sent.Offset != 5 {
+		t.Errorf("expected the next send at offset 5 but got %d: %v", sent.Offset, err)
+	}
+
+	// the reader keeps the log it loaded to poll, a restart reads the send
+	polled, _ = newStoreReader(leader).Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 5}}, ctx)
+	if !slices.Equal(polled.Messages["luck"], []Message{NewMessage(5, 50)}) {
+		t.Errorf("expected the record sent after the delete but polled %v", polled.Messages["luck"])
+	}
+}
+
+func TestDescribeKey(t *testing.T) {
+	leader, reader := newAdminTestServers(t)
+	ctx := context.TODO()
+
+	reply, err := leader.DescribeKey(&DescribeKeyMessage{MessageType: "describe_key", Key: "luck"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Leader != "n0" || reply.Epoch == 0 || !slices.Equal(reply.Replicas, []string{"n0"}) || !slices.Equal(reply.InSync, []string{"n0"}) {
+		t.Errorf("expected n0 to lead and be in sync but got %+v", reply)
+	}
+
+	if reply.LogStart != 0 || reply.LogEnd != 5 || reply.HighWatermark != 5 {
+		t.Errorf("expected offsets 0 to 5 but got %+v", reply)
+	}
+
+	// without a cached log the offsets come from lin-kv
+	stored, err := reader.DescribeKey(&DescribeKeyMessage{MessageType: "describe_key", Key: "luck"}, ctx)
+	if err != nil || stored.LogEnd != 5 || len(stored.InSync) != 0 {
+		t.Errorf("expected the stored log end 5 without in-sync replicas but got %+v: %v", stored, err)
+	}
+
+	if _, err := leader.DescribeKey(&DescribeKeyMessage{MessageType: "describe_key", Key: "missing"}, ctx); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Errorf("expected unknown keys to not exist but got %v", err)
+	}
+}
+
+func TestListKeys(t *testing.T) {
+	leader, _ := newAdminTestServers(t)
+	ctx := context.TODO()
+	leader.CommitOffsets(&CommitOffsets{MessageType: "commit_offsets", Offsets: Offsets{"luck": 2}}, ctx)
+
+	reply := leader.ListKeys(&ListKeysMessage{MessageType: "list_keys"}, ctx)
+	info, ok := reply.Keys["luck"]
+	if !ok || len(reply.Keys) != 1 {
+		t.Fatalf("expected only luck to be listed but got %v", reply.Keys)
+	}
+
+	if info.LogStart != 0 || info.LogEnd != 5 || info.HighWatermark != 5 || info.Leader != "n0" {
+		t.Errorf("expected the leader's offsets but got %+v", info)
+	}
+
+	if info.Committed == nil || *info.Committed != 2 {
+		t.Errorf("expected committed offset 2 but got %v", info.Committed)
+	}
+}
@@ -0,0 +1,112 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// SendBatch appends the entries of a batch, forwarding the entries of every
+// other leader as one batch per leader in parallel. Entries of the same key
+// are appended in batch order, entries of different keys concurrently.
+func (s *KafkaSever) SendBatch(msg *SendBatchMessage, ctx context.Context) SendBatchMessageReply {
+	results := make([]SendBatchResult, len(msg.Entries))
+	local := make([]int, 0, len(msg.Entries))
+	remote := make(map[string][]int)
+	leases := make(map[string]lease)
+	for i, entry := range msg.Entries {
+		// a forwarded batch isn't split again, its entries are sent one by
+		// one should the leadership have moved on
+		if msg.Forwarded {
+			local = append(local, i)
+			continue
+		}
+
+		l, ok := leases[entry.Key]
+		if !ok {
+			var err error
+			if l, err = s.leaderFor(ctx, entry.Key); err != nil {
+				log.Printf("failed to find leader of %s: %v", entry.Key, err)
+				results[i] = batchError(err)
+				continue
+			}
+			leases[entry.Key] = l
+		}
+
+		if target := s.route(entry.Key, l); target != s.node.ID() {
+			remote[target] = append(remote[target], i)
+		} else {
+			local = append(local, i)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for target, entries := range remote {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.forwardBatch(ctx, target, msg.Entries, entries, leases, results)
+		}()
+	}
+
+	byKey := make(map[string][]int)
+	for _, i := range local {
+		byKey[msg.Entries[i].Key] = append(byKey[msg.Entries[i].Key], i)
+	}
+	for _, entries := range byKey {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, i := range entries {
+				reply, err := s.Send(msg.Entries[i].send(), ctx)
+				if err != nil {
+					results[i] = batchError(err)
+					continue
+				}
+				results[i] = SendBatchResult{Offset: reply.Offset}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return msg.Reply(results)
+}
+
+// forwardBatch sends the entries at indexes to target as one batch and fills
+// in their results.
+func (s *KafkaSever) forwardBatch(ctx context.Context, target string, entries []SendBatchEntry, indexes []int, leases map[string]lease, results []SendBatchResult) {
+	batch := &SendBatchMessage{MessageType: "send_batch", Entries: make([]SendBatchEntry, 0, len(indexes))}
+	for _, i := range indexes {
+		batch.Entries = append(batch.Entries, entries[i])
+	}
+
+	reply, err := forwardTo[SendBatchMessageReply](ctx, s.node, target, batch)
+	if err == nil && len(reply.Results) != len(indexes) {
+		err = maelstrom.NewRPCError(maelstrom.Crash, "batch reply doesn't match the forwarded entries")
+	}
+	if err != nil {
+		log.Printf("failed forwarding batch of %d sends to %s: %v", len(indexes), target, err)
+		failed := make(map[string]error)
+		for _, i := range indexes {
+			key := entries[i].Key
+			if _, ok := failed[key]; !ok {
+				failed[key] = s.forwardFailed(key, leases[key], target, err)
+			}
+			results[i] = batchError(failed[key])
+		}
+		return
+	}
+
+	for j, i := range indexes {
+		results[i] = reply.Results[j]
+	}
+}
+
+func batchError(err error) SendBatchResult {
+	if rpcErr, ok := err.(*maelstrom.RPCError); ok {
+		return SendBatchResult{Error: &SendBatchError{Code: rpcErr.Code, Text: rpcErr.Text}}
+	}
+	return SendBatchResult{Error: &SendBatchError{Code: maelstrom.Crash, Text: err.Error()}}
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestSendBatch(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 10, ProducerID: "p1", Seq: 0}, ctx)
+
+	batch := SendBatchMessage{MessageType: "send_batch", Entries: []SendBatchEntry{
+		{Key: "luck", Value: 11},
+		{Key: "fortune", Value: 20},
+		{Key: "luck", Value: 12},
+		// skips sequence number 1
+		{Key: "luck", Value: 13, ProducerID: "p1", Seq: 2},
+		{Key: "fortune", Value: 21},
+	}}
+	reply := kafkaServer.SendBatch(&batch, ctx)
+
+	if reply.MessageType != "send_batch_ok" {
+		t.Errorf("expected reply of type 'send_batch_ok' but was %s", reply.MessageType)
+	}
+
+	if len(reply.Results) != len(batch.Entries) {
+		t.Fatalf("expected a result per entry but got %v", reply.Results)
+	}
+
+	for i, offset := range []int{1, 0, 2, -1, 1} {
+		result := reply.Results[i]
+		if offset < 0 {
+			if result.Error == nil || result.Error.Code != maelstrom.PreconditionFailed {
+				t.Errorf("expected entry %d to be rejected but got %+v", i, result)
+			}
+			continue
+		}
+
+		if result.Error != nil || result.Offset != offset {
+			t.Errorf("expected entry %d at offset %d but got %+v", i, offset, result)
+		}
+	}
+
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); len(messages) != 3 || messages[2].value() != 12 {
+		t.Errorf("expected entries of a key to be appended in order but log was %v", messages)
+	}
+}
+
+func TestClientsCantMarkBatchesForwarded(t *testing.T) {
+	batch := SendBatchMessage{}
+	if err := json.Unmarshal([]byte(`{"type":"send_batch","entries":[],"forwarded":true}`), &batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if batch.Forwarded {
+		t.Error("expected a batch from a client not to be marked forwarded")
+	}
+}
@@ -0,0 +1,733 @@
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	FSYNC_ALWAYS    string        = "always"
+	FSYNC_INTERVAL  string        = "interval"
+	FSYNC_NEVER     string        = "never"
+	FSYNC_FREQUENCY time.Duration = 1 * time.Second
+)
+
+const (
+	LOG_FILE_SUFFIX   string = ".log"
+	INDEX_FILE_SUFFIX string = ".index"
+	META_FILE         string = "meta.json"
+	KEY_DIR_PREFIX    string = "key-"
+	INDEX_ENTRY_SIZE  int    = 16
+)
+
+// fileLogMeta is the part of a key's log that isn't in its segments, the log
+// start and the epoch of the leader that last wrote the tail segment.
+type fileLogMeta struct {
+	Start int `json:"start"`
+	Epoch int `json:"epoch"`
+}
+
+// indexEntry maps the offset of a record to its position in the segment's
+// log file.
+type indexEntry struct {
+	offset   int64
+	position int64
+}
+
+// fileLog is the open log of one key. Only the tail segment is kept open for
+// appending, the others are read from their files when needed.
+type fileLog struct {
+	dir       string
+	meta      fileLogMeta
+	segments  []int
+	end       int
+	tail      *os.File
+	tailIndex *os.File
+	tailSize  int64
+	synced    time.Time
+	dirty     bool
+}
+
+// fileStore keeps every key's log in a directory of its own, one
+// "<segment>.log" file of newline separated JSON records and one
+// "<segment>.index" file of big-endian (offset, position) pairs per segment,
+// next to a meta.json file. The directory is resolved on first use as node
+// ids are only known once the node is initialized.
+//
+// Records are written with the fsync policy: FSYNC_ALWAYS syncs every write,
+// FSYNC_INTERVAL syncs a write once the last sync is FSYNC_FREQUENCY old, and
+// Syncer syncs the writes left over every FSYNC_FREQUENCY, and FSYNC_NEVER
+// leaves it to the operating system. Segments and their indexes are synced
+// when they are closed unless the policy is FSYNC_NEVER.
+//
+// Opening a log recovers it: the tail segment is scanned, a record torn by a
+// crash is cut off and its index is rebuilt, as is every index that is
+// missing or doesn't match its log file.
+type fileStore struct {
+	dir         func() string
+	segmentSize int
+	fsync       string
+	lock        *sync.Mutex
+	logs        map[string]*fileLog
+}
+
+func newFileStore(dir func() string, segmentSize int, fsync string) (*fileStore, error) {
+	if fsync == "" {
+		fsync = FSYNC_ALWAYS
+	}
+	if !slices.Contains([]string{FSYNC_ALWAYS, FSYNC_INTERVAL, FSYNC_NEVER}, fsync) {
+		return nil, fmt.Errorf("unknown fsync policy %q", fsync)
+	}
+
+	return &fileStore{dir: dir, segmentSize: max(segmentSize, 1), fsync: fsync, lock: &sync.Mutex{}, logs: make(map[string]*fileLog)}, nil
+}
+
+func (s *fileStore) segmentStart(offset int) int {
+	return offset - offset%s.segmentSize
+}
+
+func (fl *fileLog) logPath(segment int) string {
+	return filepath.Join(fl.dir, fmt.Sprintf("%020d%s", segment, LOG_FILE_SUFFIX))
+}
+
+func (fl *fileLog) indexPath(segment int) string {
+	return filepath.Join(fl.dir, fmt.Sprintf("%020d%s", segment, INDEX_FILE_SUFFIX))
+}
+
+// keyDirName returns the name of the directory of key. Keys are escaped and
+// prefixed, so keys like ".." can't name a directory outside the node's.
+func keyDirName(key string) string {
+	return KEY_DIR_PREFIX + url.PathEscape(key)
+}
+
+// open returns the log of key, recovering it from its files the first time.
+// A key without a directory doesn't exist unless create is set. The caller
+// holds s.lock.
+func (s *fileStore) open(key string, create bool) (*fileLog, error) {
+	if fl, ok := s.logs[key]; ok {
+		return fl, nil
+	}
+
+	fl := &fileLog{dir: filepath.Join(s.dir(), keyDirName(key)), synced: time.Now()}
+	if _, err := os.Stat(fl.dir); errors.Is(err, fs.ErrNotExist) {
+		if !create {
+			return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, fmt.Sprintf("no log of %s", key))
+		}
+		if err := os.MkdirAll(fl.dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.recover(fl); err != nil {
+		log.Printf("failed to recover log of %s: %v", key, err)
+		return nil, err
+	}
+
+	s.logs[key] = fl
+	return fl, nil
+}
+
+// recover loads the meta and segments of fl from its directory.
+func (s *fileStore) recover(fl *fileLog) error {
+	meta, err := os.ReadFile(filepath.Join(fl.dir, META_FILE))
+	if err == nil {
+		err = json.Unmarshal(meta, &fl.meta)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	entries, err := os.ReadDir(fl.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), LOG_FILE_SUFFIX)
+		if !ok {
+			continue
+		}
+		segment, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		fl.segments = append(fl.segments, segment)
+	}
+	slices.Sort(fl.segments)
+
+	fl.end = fl.meta.Start
+	for i, segment := range fl.segments {
+		tail := i == len(fl.segments)-1
+		if !tail && fl.indexValid(segment) {
+			continue
+		}
+
+		records, err := s.recoverSegment(fl, segment)
+		if err != nil {
+			return err
+		}
+		if tail {
+			fl.end = max(fl.end, segment*s.segmentSize)
+			if len(records) > 0 {
+				fl.end = max(fl.end, records[len(records)-1].Offset+1)
+			}
+		}
+	}
+
+	if len(fl.segments) > 0 {
+		return s.openTail(fl, fl.segments[len(fl.segments)-1])
+	}
+	return nil
+}
+
+// indexValid checks that the index of a closed segment is whole and points
+// into the segment's log file, an index that wasn't synced before a crash
+// may be short or hold garbage.
+func (fl *fileLog) indexValid(segment int) bool {
+	logInfo, err := os.Stat(fl.logPath(segment))
+	if err != nil {
+		return false
+	}
+	entries, err := fl.readIndex(segment)
+	if err != nil {
+		return false
+	}
+	if info, err := os.Stat(fl.indexPath(segment)); err != nil || info.Size() != int64(len(entries)*INDEX_ENTRY_SIZE) {
+		return false
+	}
+	if len(entries) == 0 {
+		return logInfo.Size() == 0
+	}
+
+	previous := indexEntry{offset: -1, position: -1}
+	for _, entry := range entries {
+		if entry.offset <= previous.offset || entry.position <= previous.position {
+			return false
+		}
+		previous = entry
+	}
+	if previous.position >= logInfo.Size() {
+		return false
+	}
+
+	// the last indexed record has to end the log file
+	records, _, valid, err := readLogFile(fl.logPath(segment), previous.position)
+	return err == nil && len(records) == 1 && records[0].Offset == int(previous.offset) && valid == logInfo.Size()
+}
+
+// recoverSegment reads the records of segment, cuts off a record torn by a
+// crash and rebuilds the segment's index.
+func (s *fileStore) recoverSegment(fl *fileLog, segment int) ([]Record, error) {
+	records, positions, valid, err := readLogFile(fl.logPath(segment), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(fl.logPath(segment)); err == nil && info.Size() > valid {
+		log.Printf("cutting off torn record of segment %d in %s at %d", segment, fl.dir, valid)
+		if err := os.Truncate(fl.logPath(segment), valid); err != nil {
+			return nil, err
+		}
+	}
+
+	index := make([]byte, 0, len(records)*INDEX_ENTRY_SIZE)
+	for i, record := range records {
+		index = appendIndexEntry(index, indexEntry{offset: int64(record.Offset), position: positions[i]})
+	}
+	return records, writeFileSynced(fl.indexPath(segment), index)
+}
+
+// readLogFile reads the records of a log file from position. It returns the
+// positions of the records and where the last complete record ends.
+func readLogFile(path string, position int64) ([]Record, []int64, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(position, io.SeekStart); err != nil {
+		return nil, nil, 0, err
+	}
+
+	records := make([]Record, 0)
+	positions := make([]int64, 0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a record without its newline was torn
+			return records, positions, position, nil
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		record := Record{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return records, positions, position, nil
+		}
+		records = append(records, record)
+		positions = append(positions, position)
+		position += int64(len(line))
+	}
+}
+
+// openTail opens segment for appending as the tail of fl.
+func (s *fileStore) openTail(fl *fileLog, segment int) error {
+	if err := fl.closeTail(s.fsync != FSYNC_NEVER); err != nil {
+		return err
+	}
+
+	tail, err := os.OpenFile(fl.logPath(segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	tailIndex, err := os.OpenFile(fl.indexPath(segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		tail.Close()
+		return err
+	}
+	info, err := tail.Stat()
+	if err != nil {
+		tail.Close()
+		tailIndex.Close()
+		return err
+	}
+
+	if !slices.Contains(fl.segments, segment) {
+		fl.segments = append(fl.segments, segment)
+	}
+	fl.tail, fl.tailIndex, fl.tailSize = tail, tailIndex, info.Size()
+	return nil
+}
+
+func (fl *fileLog) closeTail(sync bool) error {
+	if fl.tail == nil {
+		return nil
+	}
+
+	if sync {
+		if err := fl.tail.Sync(); err != nil {
+			return err
+		}
+		if err := fl.tailIndex.Sync(); err != nil {
+			return err
+		}
+	}
+	fl.dirty = fl.dirty && !sync
+	fl.tail.Close()
+	fl.tailIndex.Close()
+	fl.tail, fl.tailIndex = nil, nil
+	return nil
+}
+
+// append writes record to the segment holding its offset, which becomes the
+// tail. The caller holds s.lock.
+func (s *fileStore) append(fl *fileLog, record Record) error {
+	segment := record.Offset / s.segmentSize
+	if fl.tail == nil || segment != fl.segments[len(fl.segments)-1] {
+		if err := s.openTail(fl, segment); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := fl.tail.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+	entry := appendIndexEntry(nil, indexEntry{offset: int64(record.Offset), position: fl.tailSize})
+	if _, err := fl.tailIndex.Write(entry); err != nil {
+		return err
+	}
+
+	fl.tailSize += int64(len(encoded) + 1)
+	fl.end = record.Offset + 1
+	fl.dirty = true
+	return s.sync(fl)
+}
+
+func (s *fileStore) sync(fl *fileLog) error {
+	if s.fsync == FSYNC_NEVER || (s.fsync == FSYNC_INTERVAL && time.Since(fl.synced) < FSYNC_FREQUENCY) {
+		return nil
+	}
+
+	return fl.syncTail()
+}
+
+func (fl *fileLog) syncTail() error {
+	fl.synced = time.Now()
+	if fl.tail == nil || !fl.dirty {
+		return nil
+	}
+
+	fl.dirty = false
+	return fl.tail.Sync()
+}
+
+// Syncer syncs the writes FSYNC_INTERVAL left unsynced every FSYNC_FREQUENCY,
+// so the last writes before a quiet period don't wait for the next write.
+func (s *fileStore) Syncer(ctx context.Context) {
+	if s.fsync != FSYNC_INTERVAL {
+		return
+	}
+
+	ticker := time.NewTicker(FSYNC_FREQUENCY)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.syncAll()
+		}
+	}
+}
+
+func (s *fileStore) syncAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, fl := range s.logs {
+		if err := fl.syncTail(); err != nil {
+			log.Printf("failed to sync log of %s: %v", key, err)
+		}
+	}
+}
+
+// storeMeta replaces the meta file of fl, through a renamed temporary file so
+// a crash leaves either the old or the new one.
+func (fl *fileLog) storeMeta(meta fileLogMeta) error {
+	if meta == fl.meta {
+		return nil
+	}
+
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSynced(filepath.Join(fl.dir, META_FILE), encoded); err != nil {
+		return err
+	}
+
+	fl.meta = meta
+	return nil
+}
+
+// writeFileSynced replaces path with data through a renamed temporary file.
+func writeFileSynced(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func appendIndexEntry(index []byte, entry indexEntry) []byte {
+	index = binary.BigEndian.AppendUint64(index, uint64(entry.offset))
+	return binary.BigEndian.AppendUint64(index, uint64(entry.position))
+}
+
+func (fl *fileLog) readIndex(segment int) ([]indexEntry, error) {
+	data, err := os.ReadFile(fl.indexPath(segment))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]indexEntry, 0, len(data)/INDEX_ENTRY_SIZE)
+	for i := 0; i+INDEX_ENTRY_SIZE <= len(data); i += INDEX_ENTRY_SIZE {
+		entries = append(entries, indexEntry{
+			offset:   int64(binary.BigEndian.Uint64(data[i:])),
+			position: int64(binary.BigEndian.Uint64(data[i+8:])),
+		})
+	}
+	return entries, nil
+}
+
+// seek returns how many records of segment come before offset and the
+// position of the first record at or after it, found in the segment's index.
+func (fl *fileLog) seek(segment int, offset int) (int, int64, error) {
+	entries, err := fl.readIndex(segment)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].offset >= int64(offset) })
+	if i < len(entries) {
+		return i, entries[i].position, nil
+	}
+
+	info, err := os.Stat(fl.logPath(segment))
+	if err != nil {
+		return 0, 0, err
+	}
+	return i, info.Size(), nil
+}
+
+// removeSegment deletes the files of segment.
+func (fl *fileLog) removeSegment(segment int) error {
+	if fl.tail != nil && segment == fl.segments[len(fl.segments)-1] {
+		fl.closeTail(false)
+	}
+	if err := os.Remove(fl.logPath(segment)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(fl.indexPath(segment)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	fl.segments = slices.DeleteFunc(fl.segments, func(s int) bool { return s == segment })
+	return nil
+}
+
+// Append fails its precondition unless l ends where the stored log ends and
+// its tail was cached at the stored epoch. Like a new lin-kv segment, a record
+// starting a segment only needs the end to match.
+func (s *fileStore) Append(ctx context.Context, key string, l recordLog, cachedEpoch int, epoch int, record Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fl, err := s.open(key, true)
+	if err != nil {
+		return err
+	}
+
+	if fl.end != l.end || (fl.meta.Epoch != cachedEpoch && s.segmentStart(fl.end) != fl.end) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("log of %s ends at %d in epoch %d, not at %d in epoch %d", key, fl.end, fl.meta.Epoch, l.end, cachedEpoch))
+	}
+
+	if err := s.append(fl, record); err != nil {
+		return err
+	}
+	return fl.storeMeta(fileLogMeta{Start: fl.meta.Start, Epoch: epoch})
+}
+
+func (s *fileStore) Read(ctx context.Context, key string, from int) (recordLog, int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fl, err := s.open(key, false)
+	if err != nil {
+		return recordLog{}, 0, err
+	}
+
+	l := newRecordLog()
+	l.start, l.end = fl.meta.Start, fl.end
+	first := s.segmentStart(max(from, fl.meta.Start)) / s.segmentSize
+	for _, segment := range fl.segments {
+		if segment < first {
+			continue
+		}
+
+		position := int64(0)
+		if segment == fl.meta.Start/s.segmentSize {
+			if _, position, err = fl.seek(segment, fl.meta.Start); err != nil {
+				return recordLog{}, 0, err
+			}
+		}
+
+		records, _, _, err := readLogFile(fl.logPath(segment), position)
+		if err != nil {
+			return recordLog{}, 0, err
+		}
+		l.records = append(l.records, records...)
+	}
+
+	l.records = l.from(fl.meta.Start)
+	return l, fl.meta.Epoch, nil
+}
+
+// TrimHead moves the log start in the meta file and deletes the segments
+// before it. A deleted log keeps an empty tail segment at its start so it is
+// recovered with the right end.
+func (s *fileStore) TrimHead(ctx context.Context, key string, l recordLog, start int, epoch int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fl, err := s.open(key, true)
+	if err != nil {
+		return err
+	}
+
+	return s.trimHead(fl, start, epoch)
+}
+
+func (s *fileStore) trimHead(fl *fileLog, start int, epoch int) error {
+	if err := fl.storeMeta(fileLogMeta{Start: max(fl.meta.Start, start), Epoch: epoch}); err != nil {
+		return err
+	}
+
+	for _, segment := range slices.Clone(fl.segments) {
+		if (segment+1)*s.segmentSize <= fl.meta.Start {
+			if err := fl.removeSegment(segment); err != nil {
+				return err
+			}
+		}
+	}
+
+	fl.end = max(fl.end, fl.meta.Start)
+	if len(fl.segments) == 0 {
+		return s.openTail(fl, fl.meta.Start/s.segmentSize)
+	}
+	return nil
+}
+
+// Truncate cuts the segment holding end at the first record at or after end,
+// found in its index, and deletes the segments after it.
+func (s *fileStore) Truncate(ctx context.Context, key string, l recordLog, end int, epoch int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fl, err := s.open(key, true)
+	if err != nil {
+		return err
+	}
+
+	if err := s.truncate(fl, end); err != nil {
+		return err
+	}
+	return fl.storeMeta(fileLogMeta{Start: fl.meta.Start, Epoch: epoch})
+}
+
+func (s *fileStore) truncate(fl *fileLog, end int) error {
+	tail := end / s.segmentSize
+	for _, segment := range slices.Clone(fl.segments) {
+		if segment > tail {
+			if err := fl.removeSegment(segment); err != nil {
+				return err
+			}
+		}
+	}
+
+	if slices.Contains(fl.segments, tail) {
+		count, position, err := fl.seek(tail, end)
+		if err != nil {
+			return err
+		}
+		if err := fl.closeTail(false); err != nil {
+			return err
+		}
+		if err := os.Truncate(fl.logPath(tail), position); err != nil {
+			return err
+		}
+		if err := os.Truncate(fl.indexPath(tail), int64(count*INDEX_ENTRY_SIZE)); err != nil {
+			return err
+		}
+	}
+
+	fl.end = max(end, fl.meta.Start)
+	return s.openTail(fl, tail)
+}
+
+// Compact rewrites the closed segments before upTo that lost records,
+// through renamed temporary files.
+func (s *fileStore) Compact(ctx context.Context, key string, before recordLog, compacted recordLog, upTo int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fl, err := s.open(key, false)
+	if err != nil {
+		return err
+	}
+
+	if len(fl.segments) == 0 {
+		return nil
+	}
+
+	tail := fl.segments[len(fl.segments)-1]
+	for _, segment := range fl.segments {
+		offset := segment * s.segmentSize
+		if segment >= tail || offset+s.segmentSize > upTo {
+			break
+		}
+
+		records := compacted.upTo(offset + s.segmentSize).from(offset)
+		if len(before.upTo(offset+s.segmentSize).from(offset)) == len(records) {
+			continue
+		}
+
+		data := make([]byte, 0)
+		index := make([]byte, 0, len(records)*INDEX_ENTRY_SIZE)
+		for _, record := range records {
+			encoded, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			index = appendIndexEntry(index, indexEntry{offset: int64(record.Offset), position: int64(len(data))})
+			data = append(append(data, encoded...), '\n')
+		}
+
+		if err := writeFileSynced(fl.logPath(segment), data); err != nil {
+			log.Printf("failed to compact segment %d of %s: %v", segment, key, err)
+			return err
+		}
+		if err := writeFileSynced(fl.indexPath(segment), index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mirror brings the files up to date with a follower's log l: the records
+// from from onwards are replaced and the log start follows l. A follower that
+// started over past its end drops its segments.
+func (s *fileStore) Mirror(ctx context.Context, key string, l recordLog, from int, epoch int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fl, err := s.open(key, true)
+	if err != nil {
+		return err
+	}
+
+	if from > fl.end {
+		for _, segment := range slices.Clone(fl.segments) {
+			if err := fl.removeSegment(segment); err != nil {
+				return err
+			}
+		}
+		fl.end = from
+		if err := s.trimHead(fl, from, epoch); err != nil {
+			return err
+		}
+	} else if from < fl.end {
+		if err := s.truncate(fl, from); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range l.from(from) {
+		if err := s.append(fl, record); err != nil {
+			return err
+		}
+	}
+
+	if l.start > fl.meta.Start {
+		return s.trimHead(fl, l.start, epoch)
+	}
+	return fl.storeMeta(fileLogMeta{Start: fl.meta.Start, Epoch: epoch})
+}
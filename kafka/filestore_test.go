@@ -0,0 +1,284 @@
+package kafka
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newTestFileStore(t *testing.T, dir string) *fileStore {
+	store, err := newFileStore(func() string { return dir }, 2, FSYNC_ALWAYS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// appendRecords appends a record for each value to the stored log of key and
+// returns the cached log.
+func appendRecords(t *testing.T, store *fileStore, key string, values ...int) recordLog {
+	l := newRecordLog()
+	for offset, value := range values {
+		record := Record{Offset: offset, Value: value}
+		if err := store.Append(context.TODO(), key, l, 1, 1, record); err != nil {
+			t.Fatal(err)
+		}
+		l.append([]Record{record}, offset+1)
+	}
+	return l
+}
+
+func TestFileStoreAppend(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestFileStore(t, dir)
+	ctx := context.TODO()
+	l := appendRecords(t, store, "luck", 0, 10, 20, 30, 40)
+
+	files, _ := filepath.Glob(filepath.Join(dir, keyDirName("luck"), "*"+LOG_FILE_SUFFIX))
+	if len(files) != 3 {
+		t.Errorf("expected 3 segment files but found %v", files)
+	}
+
+	stored, epoch, err := store.Read(ctx, "luck", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Message{NewMessage(2, 20), NewMessage(3, 30), NewMessage(4, 40)}
+	if stored.end != 5 || epoch != 1 || !slices.Equal(toMessages(stored.records), expected) {
+		t.Errorf("expected %v up to 5 at epoch 1 but got %v up to %d at epoch %d", expected, stored.records, stored.end, epoch)
+	}
+
+	// a stale cache or epoch fails the precondition instead of overwriting the tail
+	if err := store.Append(ctx, "luck", l.upTo(4), 1, 1, Record{Offset: 4, Value: 41}); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected append from a stale cache to fail its precondition but got %v", err)
+	}
+	if err := store.Append(ctx, "luck", l, 0, 1, Record{Offset: 5, Value: 50}); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected append cached in another epoch to fail its precondition but got %v", err)
+	}
+
+	if _, _, err := store.Read(ctx, "prize", 0); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Errorf("expected missing log to not exist but got %v", err)
+	}
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestFileStore(t, dir)
+	appendRecords(t, store, "luck", 0, 10, 20, 30, 40)
+
+	// a crash tore the last record and lost an index
+	tail, err := os.OpenFile(filepath.Join(dir, keyDirName("luck"), "00000000000000000002"+LOG_FILE_SUFFIX), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail.WriteString(`{"offset":5,"val`)
+	tail.Close()
+	os.Remove(filepath.Join(dir, keyDirName("luck"), "00000000000000000000"+INDEX_FILE_SUFFIX))
+
+	recovered := newTestFileStore(t, dir)
+	ctx := context.TODO()
+	stored, epoch, err := recovered.Read(ctx, "luck", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Message{NewMessage(0, 0), NewMessage(1, 10), NewMessage(2, 20), NewMessage(3, 30), NewMessage(4, 40)}
+	if stored.end != 5 || epoch != 1 || !slices.Equal(toMessages(stored.records), expected) {
+		t.Errorf("expected %v up to 5 at epoch 1 but got %v up to %d at epoch %d", expected, stored.records, stored.end, epoch)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, keyDirName("luck"), "00000000000000000000"+INDEX_FILE_SUFFIX)); err != nil {
+		t.Errorf("expected the lost index to be rebuilt: %v", err)
+	}
+
+	// appends continue after the torn record was cut off
+	if err := recovered.Append(ctx, "luck", stored, 1, 2, Record{Offset: 5, Value: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _, _ := recovered.Read(ctx, "luck", 4); !slices.Equal(toMessages(stored.records), []Message{NewMessage(4, 40), NewMessage(5, 50)}) {
+		t.Errorf("expected the appended record after the recovered ones but got %v", stored.records)
+	}
+}
+
+func TestFileStoreRecoversFromCorruptRecords(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestFileStore(t, dir)
+	appendRecords(t, store, "luck", 0, 10, 20, 30, 40)
+
+	// a whole but corrupt line followed by more writes, and a closed
+	// segment's index that wasn't synced
+	tail, err := os.OpenFile(filepath.Join(dir, keyDirName("luck"), "00000000000000000002"+LOG_FILE_SUFFIX), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail.WriteString("{\"offset\":5,\"value\":x}\n{\"offset\":6,\"value\":60}\n")
+	tail.Close()
+	os.WriteFile(filepath.Join(dir, keyDirName("luck"), "00000000000000000001"+INDEX_FILE_SUFFIX), []byte("garbage"), 0o644)
+
+	recovered := newTestFileStore(t, dir)
+	ctx := context.TODO()
+	stored, _, err := recovered.Read(ctx, "luck", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Message{NewMessage(0, 0), NewMessage(1, 10), NewMessage(2, 20), NewMessage(3, 30), NewMessage(4, 40)}
+	if stored.end != 5 || !slices.Equal(toMessages(stored.records), expected) {
+		t.Errorf("expected the log to be cut at the corrupt record, %v up to 5, but got %v up to %d", expected, stored.records, stored.end)
+	}
+
+	// the rebuilt index finds records in the middle of the segment
+	if err := recovered.TrimHead(ctx, "luck", stored, 3, 1); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _, _ := recovered.Read(ctx, "luck", 0); !slices.Equal(toMessages(stored.records), expected[3:]) {
+		t.Errorf("expected %v from 3 but got %v", expected[3:], stored.records)
+	}
+}
+
+func TestFileStoreKeepsKeysInTheirDirectories(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "n0")
+	store := newTestFileStore(t, dir)
+	appendRecords(t, store, "..", 0)
+	appendRecords(t, store, ".", 1)
+	appendRecords(t, store, "a/b", 2)
+
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected only the node directory next to it but found %v: %v", entries, err)
+	}
+
+	for value, key := range []string{"..", ".", "a/b"} {
+		stored, _, err := newTestFileStore(t, dir).Read(context.TODO(), key, 0)
+		if err != nil || !slices.Equal(toMessages(stored.records), []Message{NewMessage(0, value)}) {
+			t.Errorf("expected %s to hold only %d but got %v: %v", key, value, stored.records, err)
+		}
+	}
+}
+
+func TestFileStoreSyncsIntervalWrites(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newFileStore(func() string { return dir }, 2, FSYNC_INTERVAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, store, "luck", 0, 10, 20)
+
+	if !store.logs["luck"].dirty {
+		t.Fatal("expected writes within FSYNC_FREQUENCY to be left unsynced")
+	}
+	store.syncAll()
+	if store.logs["luck"].dirty {
+		t.Error("expected the periodic sync to sync the tail")
+	}
+}
+
+func TestFileStoreTruncateAndTrimHead(t *testing.T) {
+	store := newTestFileStore(t, t.TempDir())
+	ctx := context.TODO()
+	l := appendRecords(t, store, "luck", 0, 10, 20, 30, 40)
+
+	if err := store.Truncate(ctx, "luck", l, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, epoch, err := store.Read(ctx, "luck", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Message{NewMessage(0, 0), NewMessage(1, 10), NewMessage(2, 20)}
+	if stored.end != 3 || epoch != 2 || !slices.Equal(toMessages(stored.records), expected) {
+		t.Errorf("expected %v up to 3 at epoch 2 but got %v up to %d at epoch %d", expected, stored.records, stored.end, epoch)
+	}
+
+	if err := store.TrimHead(ctx, "luck", stored, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _, _ := store.Read(ctx, "luck", 0); stored.start != 1 || !slices.Equal(toMessages(stored.records), expected[1:]) {
+		t.Errorf("expected %v from 1 but got %v from %d", expected[1:], stored.records, stored.start)
+	}
+
+	// a deleted log is recovered with its end
+	if err := store.TrimHead(ctx, "luck", stored, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	stored, _, err = newTestFileStore(t, store.dir()).Read(ctx, "luck", 0)
+	if err != nil || stored.start != 3 || stored.end != 3 || len(stored.records) != 0 {
+		t.Errorf("expected an empty log from 3 to 3 but got %v from %d to %d: %v", stored.records, stored.start, stored.end, err)
+	}
+}
+
+func TestFileStoreMirror(t *testing.T) {
+	store := newTestFileStore(t, t.TempDir())
+	ctx := context.TODO()
+	l := appendRecords(t, store, "luck", 0, 10, 20, 30)
+
+	// the leader replaced the records from offset 2
+	l.truncate(2)
+	l.append(records(NewMessage(2, 21), NewMessage(3, 31), NewMessage(4, 41)), 5)
+	l.trimHead(1)
+	if err := store.Mirror(ctx, "luck", l, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, epoch, err := store.Read(ctx, "luck", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Message{NewMessage(1, 10), NewMessage(2, 21), NewMessage(3, 31), NewMessage(4, 41)}
+	if stored.start != 1 || stored.end != 5 || epoch != 2 || !slices.Equal(toMessages(stored.records), expected) {
+		t.Errorf("expected %v from 1 to 5 at epoch 2 but got %v from %d to %d at epoch %d", expected, stored.records, stored.start, stored.end, epoch)
+	}
+
+	// a follower too far behind starts over at the leader's log start
+	l.reset(8)
+	l.append(records(NewMessage(8, 80)), 9)
+	if err := store.Mirror(ctx, "luck", l, 8, 3); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _, _ := store.Read(ctx, "luck", 0); stored.start != 8 || stored.end != 9 || !slices.Equal(toMessages(stored.records), []Message{NewMessage(8, 80)}) {
+		t.Errorf("expected only offset 8 from 8 to 9 but got %v from %d to %d", stored.records, stored.start, stored.end)
+	}
+}
+
+func TestKafkaFileStorageSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	leader := newTestKafkaServer(kvstore.NewMemoryStore())
+	if err := leader.UseFileStorage(dir, FSYNC_INTERVAL); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	for value := range 3 {
+		if _, err := leader.Send(&SendMessage{MessageType: "send", Key: "luck", Value: value}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restarted := newTestKafkaServer(leader.seqKV)
+	restarted.linKV = leader.linKV
+	if err := restarted.UseFileStorage(dir, FSYNC_INTERVAL); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := restarted.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 3}, ctx)
+	if err != nil || sent.Offset != 3 {
+		t.Errorf("expected the send after the restart at offset 3 but got %d: %v", sent.Offset, err)
+	}
+
+	polled, _ := restarted.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}}, ctx)
+	expected := []Message{NewMessage(0, 0), NewMessage(1, 1), NewMessage(2, 2), NewMessage(3, 3)}
+	if !slices.Equal(polled.Messages["luck"], expected) {
+		t.Errorf("expected %v after the restart but polled %v", expected, polled.Messages["luck"])
+	}
+
+	if err := restarted.UseFileStorage(dir, "sometimes"); err == nil {
+		t.Error("expected an unknown fsync policy to be rejected")
+	}
+}
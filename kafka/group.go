@@ -0,0 +1,332 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	GROUP_KEY_PREFIX      string        = "group-"
+	GROUP_SESSION_TIMEOUT time.Duration = 3 * time.Second
+)
+
+// groupState is the value of a consumer group in lin-kv, the subscribed keys
+// of every member and the generation of their assignment.
+type groupState struct {
+	Generation int                 `json:"generation"`
+	Members    map[string][]string `json:"members"`
+}
+
+// consumerGroup is the coordinator's view of a group, loaded while holding
+// the lease of the group at epoch. Heartbeats are only kept in memory,
+// members loaded by a new coordinator get a fresh session.
+type consumerGroup struct {
+	lock       *sync.Mutex
+	loaded     bool
+	epoch      int
+	state      groupState
+	heartbeats map[string]time.Time
+}
+
+func groupKey(group string) string {
+	return GROUP_KEY_PREFIX + group
+}
+
+// offsetKey returns the seq-kv key holding the committed offset of key, the
+// offsets committed without a group keep the key itself.
+func offsetKey(group string, key string) string {
+	if group == "" {
+		return key
+	}
+	return fmt.Sprintf("%s%s/%s", GROUP_KEY_PREFIX, group, key)
+}
+
+// coordinatorFor returns the lease of key, a group or transactional id, and
+// whether this node holds it. Coordinators fail over like partition leaders,
+// a replica takes an expired lease over and requests to a crashed candidate
+// move on to the next one.
+func (s *KafkaSever) coordinatorFor(ctx context.Context, key string) (lease, bool, error) {
+	l, err := s.leaderFor(ctx, key)
+	if err != nil {
+		return lease{}, false, err
+	}
+	return l, l.Leader == s.node.ID() && l.valid(time.Now()), nil
+}
+
+// group returns the locked state of group name for the coordinator at epoch,
+// loading it from lin-kv the first time, once the lease changed hands and
+// after a failed update.
+func (s *KafkaSever) group(ctx context.Context, name string, epoch int) (*consumerGroup, error) {
+	s.lock.Lock()
+	g, ok := s.groups[name]
+	if !ok {
+		g = &consumerGroup{lock: &sync.Mutex{}, heartbeats: make(map[string]time.Time)}
+		s.groups[name] = g
+	}
+	s.lock.Unlock()
+
+	g.lock.Lock()
+	if g.loaded && g.epoch == epoch {
+		return g, nil
+	}
+
+	state := groupState{Members: make(map[string][]string)}
+	if err := s.linKV.ReadInto(ctx, groupKey(name), &state); err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		g.lock.Unlock()
+		return nil, kvstore.Unavailable(err)
+	}
+
+	if g.epoch != epoch {
+		clear(g.heartbeats)
+	}
+	g.state, g.epoch, g.loaded = state, epoch, true
+	for member := range state.Members {
+		if _, ok := g.heartbeats[member]; !ok {
+			g.heartbeats[member] = time.Now()
+		}
+	}
+	return g, nil
+}
+
+// update stores members as the next generation of the group if the stored
+// group is still the one loaded, so a former coordinator can't overwrite the
+// generations of its successor. The caller holds g.lock.
+func (s *KafkaSever) update(ctx context.Context, name string, g *consumerGroup, members map[string][]string) error {
+	state := groupState{Generation: g.state.Generation + 1, Members: members}
+	if err := s.linKV.CompareAndSwap(ctx, groupKey(name), g.state, state, true); err != nil {
+		log.Printf("failed to store generation %d of group %s: %v", state.Generation, name, err)
+		// the stored group changed or is unknown, the next request reloads it
+		g.loaded = false
+		return kvstore.Unavailable(err)
+	}
+
+	log.Printf("group %s rebalanced to generation %d with members %v", name, state.Generation, slices.Sorted(maps.Keys(members)))
+	g.state = state
+	return nil
+}
+
+// assignment gives every subscribed key to the least loaded member subscribed
+// to it, breaking ties by member id, so every coordinator computes the same
+// assignment for a generation.
+func (g groupState) assignment(member string) []string {
+	subscribers := make(map[string][]string)
+	for m, keys := range g.Members {
+		for _, key := range keys {
+			subscribers[key] = append(subscribers[key], m)
+		}
+	}
+
+	assigned := make([]string, 0)
+	load := make(map[string]int)
+	for _, key := range slices.Sorted(maps.Keys(subscribers)) {
+		candidates := subscribers[key]
+		slices.SortFunc(candidates, func(a, b string) int {
+			if load[a] != load[b] {
+				return load[a] - load[b]
+			}
+			if a < b {
+				return -1
+			}
+			return 1
+		})
+
+		load[candidates[0]] += 1
+		if candidates[0] == member {
+			assigned = append(assigned, key)
+		}
+	}
+
+	return assigned
+}
+
+func (s *KafkaSever) JoinGroup(msg *JoinGroupMessage, ctx context.Context) (JoinGroupMessageReply, error) {
+	l, ok, err := s.coordinatorFor(ctx, groupKey(msg.Group))
+	if err != nil {
+		return JoinGroupMessageReply{}, err
+	}
+	if !ok {
+		return forwardToLeader[JoinGroupMessageReply](ctx, s, groupKey(msg.Group), l, msg)
+	}
+
+	g, err := s.group(ctx, msg.Group, l.Epoch)
+	if err != nil {
+		return JoinGroupMessageReply{}, err
+	}
+	defer g.lock.Unlock()
+
+	member := msg.MemberID
+	if member == "" {
+		member = fmt.Sprintf("%s-%d", s.node.ID(), time.Now().UnixNano())
+	}
+
+	if keys, ok := g.state.Members[member]; !ok || !slices.Equal(keys, msg.Keys) {
+		members := maps.Clone(g.state.Members)
+		members[member] = slices.Clone(msg.Keys)
+		if err := s.update(ctx, msg.Group, g, members); err != nil {
+			return JoinGroupMessageReply{}, err
+		}
+	}
+
+	g.heartbeats[member] = time.Now()
+	return msg.Reply(member, g.state.Generation, g.state.assignment(member)), nil
+}
+
+// Heartbeat keeps a member's session alive and hands it the current
+// generation, so members pick up rebalances caused by others.
+func (s *KafkaSever) Heartbeat(msg *HeartbeatMessage, ctx context.Context) (HeartbeatMessageReply, error) {
+	l, ok, err := s.coordinatorFor(ctx, groupKey(msg.Group))
+	if err != nil {
+		return HeartbeatMessageReply{}, err
+	}
+	if !ok {
+		return forwardToLeader[HeartbeatMessageReply](ctx, s, groupKey(msg.Group), l, msg)
+	}
+
+	g, err := s.group(ctx, msg.Group, l.Epoch)
+	if err != nil {
+		return HeartbeatMessageReply{}, err
+	}
+	defer g.lock.Unlock()
+
+	if _, ok := g.state.Members[msg.MemberID]; !ok {
+		return HeartbeatMessageReply{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("%s is not a member of group %s", msg.MemberID, msg.Group))
+	}
+
+	g.heartbeats[msg.MemberID] = time.Now()
+	return msg.Reply(g.state.Generation, g.state.assignment(msg.MemberID)), nil
+}
+
+func (s *KafkaSever) LeaveGroup(msg *LeaveGroupMessage, ctx context.Context) (LeaveGroupMessageReply, error) {
+	l, ok, err := s.coordinatorFor(ctx, groupKey(msg.Group))
+	if err != nil {
+		return LeaveGroupMessageReply{}, err
+	}
+	if !ok {
+		return forwardToLeader[LeaveGroupMessageReply](ctx, s, groupKey(msg.Group), l, msg)
+	}
+
+	g, err := s.group(ctx, msg.Group, l.Epoch)
+	if err != nil {
+		return LeaveGroupMessageReply{}, err
+	}
+	defer g.lock.Unlock()
+
+	if _, ok := g.state.Members[msg.MemberID]; ok {
+		members := maps.Clone(g.state.Members)
+		delete(members, msg.MemberID)
+		if err := s.update(ctx, msg.Group, g, members); err != nil {
+			return LeaveGroupMessageReply{}, err
+		}
+	}
+
+	delete(g.heartbeats, msg.MemberID)
+	return msg.Reply(), nil
+}
+
+// validateCommit rejects commits from members that were evicted, missed a
+// rebalance or commit keys they aren't assigned. The caller holds g.lock.
+func (g *consumerGroup) validateCommit(msg *CommitOffsets) error {
+	if _, ok := g.state.Members[msg.MemberID]; !ok {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("%s is not a member of group %s", msg.MemberID, msg.Group))
+	}
+
+	if msg.Generation != g.state.Generation {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("generation %d of group %s is stale, current generation is %d", msg.Generation, msg.Group, g.state.Generation))
+	}
+
+	assigned := g.state.assignment(msg.MemberID)
+	for key := range msg.Offsets {
+		if !slices.Contains(assigned, key) {
+			return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("%s is not assigned to %s in group %s", key, msg.MemberID, msg.Group))
+		}
+	}
+
+	return nil
+}
+
+// GroupReaper evicts members of the groups this node coordinates once they
+// missed heartbeats for GROUP_SESSION_TIMEOUT, rebalancing their keys.
+func (s *KafkaSever) GroupReaper(ctx context.Context) {
+	ticker := time.NewTicker(GROUP_SESSION_TIMEOUT / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.lock.RLock()
+			groups := maps.Clone(s.groups)
+			s.lock.RUnlock()
+
+			for name := range groups {
+				s.evictExpired(ctx, name)
+			}
+		}
+	}
+}
+
+// evictExpired evicts the expired members of group name while this node
+// coordinates it, keeping its lease.
+func (s *KafkaSever) evictExpired(ctx context.Context, name string) {
+	ctx, cancel := context.WithTimeout(ctx, GROUP_SESSION_TIMEOUT/2)
+	defer cancel()
+	l, ok, err := s.coordinatorFor(ctx, groupKey(name))
+	if err != nil || !ok {
+		return
+	}
+
+	g, err := s.group(ctx, name, l.Epoch)
+	if err != nil {
+		return
+	}
+	defer g.lock.Unlock()
+
+	members := maps.Clone(g.state.Members)
+	for member := range g.state.Members {
+		if time.Since(g.heartbeats[member]) > GROUP_SESSION_TIMEOUT {
+			log.Printf("evicting %s from group %s after missing heartbeats", member, name)
+			delete(members, member)
+		}
+	}
+
+	if len(members) == len(g.state.Members) {
+		return
+	}
+
+	if err := s.update(ctx, name, g, members); err != nil {
+		return
+	}
+	for member := range g.heartbeats {
+		if _, ok := members[member]; !ok {
+			delete(g.heartbeats, member)
+		}
+	}
+}
+
+// forwardTo sends body to dest and decodes its reply, errors returned by dest
+// are passed on as they are.
+func forwardTo[T any](ctx context.Context, node *maelstrom.Node, dest string, body any) (T, error) {
+	var reply T
+	msg, err := node.SyncRPC(ctx, dest, body)
+	if err != nil {
+		log.Printf("failed forwarding %v to %s: %v", body, dest, err)
+		return reply, err
+	}
+
+	if err := json.Unmarshal(msg.Body, &reply); err != nil {
+		log.Printf("failed to unmarshal reply from %s: %v", dest, err)
+		return reply, err
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestJoinGroupAssignsKeys(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	keys := []string{"luck", "prize", "nozzle", "charm"}
+
+	first, err := kafkaServer.JoinGroup(&JoinGroupMessage{MessageType: "join_group", Group: "g", MemberID: "c1", Keys: keys}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.MessageType != "join_group_ok" || first.Generation != 1 || len(first.Assignment) != 4 {
+		t.Errorf("expected the only member to get every key at generation 1 but got %v", first)
+	}
+
+	second, err := kafkaServer.JoinGroup(&JoinGroupMessage{MessageType: "join_group", Group: "g", MemberID: "c2", Keys: keys}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heartbeat, err := kafkaServer.Heartbeat(&HeartbeatMessage{MessageType: "heartbeat", Group: "g", MemberID: "c1"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second.Generation != 2 || heartbeat.Generation != 2 {
+		t.Errorf("expected both members at generation 2 but were %d and %d", second.Generation, heartbeat.Generation)
+	}
+
+	assigned := slices.Concat(heartbeat.Assignment, second.Assignment)
+	slices.Sort(assigned)
+	if len(second.Assignment) != 2 || !slices.Equal(assigned, slices.Sorted(slices.Values(keys))) {
+		t.Errorf("expected keys split between members but were %v and %v", heartbeat.Assignment, second.Assignment)
+	}
+
+	if rejoin, _ := kafkaServer.JoinGroup(&JoinGroupMessage{MessageType: "join_group", Group: "g", MemberID: "c2", Keys: keys}, ctx); rejoin.Generation != 2 {
+		t.Errorf("rejoining with the same keys shouldn't rebalance but moved to generation %d", rejoin.Generation)
+	}
+}
+
+func TestGroupCommitOffsets(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	joined, err := kafkaServer.JoinGroup(&JoinGroupMessage{MessageType: "join_group", Group: "g", Keys: []string{"luck"}}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := CommitOffsets{MessageType: "commit_offsets", Offsets: Offsets{"luck": 3}, Group: "g", MemberID: joined.MemberID, Generation: joined.Generation}
+	if _, err := kafkaServer.CommitOffsets(&commit, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	grouped := kafkaServer.ListCommitedOffsets(&ListCommittedOffsets{MessageType: "list_committed_offsets", Keys: []string{"luck"}, Group: "g"}, ctx)
+	if !maps.Equal(grouped.Offsets, Offsets{"luck": 3}) {
+		t.Errorf("expected luck:3 committed in group g but listed %v", grouped.Offsets)
+	}
+
+	global := kafkaServer.ListCommitedOffsets(&ListCommittedOffsets{MessageType: "list_committed_offsets", Keys: []string{"luck"}}, ctx)
+	if len(global.Offsets) != 0 {
+		t.Errorf("group commits shouldn't be listed without the group but listed %v", global.Offsets)
+	}
+
+	unassigned := CommitOffsets{MessageType: "commit_offsets", Offsets: Offsets{"prize": 1}, Group: "g", MemberID: joined.MemberID, Generation: joined.Generation}
+	if _, err := kafkaServer.CommitOffsets(&unassigned, ctx); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected commit of an unassigned key to be rejected but got %v", err)
+	}
+}
+
+func TestGroupRejectsStaleGenerations(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	first, _ := kafkaServer.JoinGroup(&JoinGroupMessage{MessageType: "join_group", Group: "g", MemberID: "c1", Keys: []string{"luck"}}, ctx)
+	kafkaServer.JoinGroup(&JoinGroupMessage{MessageType: "join_group", Group: "g", MemberID: "c2", Keys: []string{"luck"}}, ctx)
+
+	stale := CommitOffsets{MessageType: "commit_offsets", Offsets: Offsets{"luck": 1}, Group: "g", MemberID: "c1", Generation: first.Generation}
+	if _, err := kafkaServer.CommitOffsets(&stale, ctx); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected commit from generation %d to be rejected but got %v", first.Generation, err)
+	}
+
+	// c1 stops heartbeating and gets evicted
+	l, _ := kafkaServer.cachedLease(groupKey("g"))
+	g, _ := kafkaServer.group(ctx, "g", l.Epoch)
+	g.heartbeats["c1"] = time.Now().Add(-2 * GROUP_SESSION_TIMEOUT)
+	g.lock.Unlock()
+	kafkaServer.evictExpired(ctx, "g")
+
+	if _, err := kafkaServer.Heartbeat(&HeartbeatMessage{MessageType: "heartbeat", Group: "g", MemberID: "c1"}, ctx); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected heartbeat of evicted member to be rejected but got %v", err)
+	}
+
+	heartbeat, err := kafkaServer.Heartbeat(&HeartbeatMessage{MessageType: "heartbeat", Group: "g", MemberID: "c2"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if heartbeat.Generation != 3 || !slices.Equal(heartbeat.Assignment, []string{"luck"}) {
+		t.Errorf("expected luck reassigned to c2 at generation 3 but got %v", heartbeat)
+	}
+}
+
+func TestGroupReloadsAfterConflictingUpdate(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	kafkaServer.JoinGroup(&JoinGroupMessage{MessageType: "join_group", Group: "g", MemberID: "c1", Keys: []string{"luck"}}, ctx)
+
+	// a former coordinator stored a generation this one never loaded
+	members := map[string][]string{"c1": {"luck"}, "c2": {"luck"}}
+	kafkaServer.linKV.Write(ctx, groupKey("g"), groupState{Generation: 2, Members: members})
+
+	join := JoinGroupMessage{MessageType: "join_group", Group: "g", MemberID: "c3", Keys: []string{"luck"}}
+	if _, err := kafkaServer.JoinGroup(&join, ctx); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected join on a stale group to fail but got %v", err)
+	}
+
+	joined, err := kafkaServer.JoinGroup(&join, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := groupState{}
+	kafkaServer.linKV.ReadInto(ctx, groupKey("g"), &stored)
+	if joined.Generation != 3 || !slices.Equal(slices.Sorted(maps.Keys(stored.Members)), []string{"c1", "c2", "c3"}) {
+		t.Errorf("expected c3 to join c1 and c2 at generation 3 but got %v with %v", joined, stored)
+	}
+}
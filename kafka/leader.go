@@ -0,0 +1,183 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	LEASE_KEY_PREFIX     string        = "lease-"
+	LEASE_DURATION       time.Duration = 2 * time.Second
+	LEASE_RENEWAL_BEFORE time.Duration = LEASE_DURATION / 2
+)
+
+// lease grants Leader the right to append to a key until Expires, in unix
+// milliseconds. Epoch grows every time the lease changes hands and is stored
+// in the log's segments, so appends and replication from an older leader are
+// fenced off once its successor wrote.
+type lease struct {
+	Leader  string `json:"leader"`
+	Epoch   int    `json:"epoch"`
+	Expires int64  `json:"expires"`
+}
+
+func (l lease) valid(now time.Time) bool {
+	return l.Leader != "" && now.UnixMilli() < l.Expires
+}
+
+func leaseKey(key string) string {
+	return LEASE_KEY_PREFIX + key
+}
+
+// leaderFor returns the current lease of key. Replicas take over a missing or
+// expired lease and the holder renews its own lease once it is about to
+// expire, other nodes only read it.
+func (s *KafkaSever) leaderFor(ctx context.Context, key string) (lease, error) {
+	now := time.Now()
+	cached, ok := s.cachedLease(key)
+	if ok && cached.valid(now) && (cached.Leader != s.node.ID() || cached.valid(now.Add(LEASE_RENEWAL_BEFORE))) {
+		return cached, nil
+	}
+
+	current := lease{}
+	err := kvstore.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+		err := s.linKV.ReadInto(ctx, leaseKey(key), &current)
+		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			return kvstore.Unavailable(err)
+		}
+
+		start := time.Now()
+		if current.Leader != s.node.ID() && current.valid(start) || !slices.Contains(s.replicas(key), s.node.ID()) {
+			return nil
+		}
+
+		next := lease{Leader: s.node.ID(), Epoch: current.Epoch, Expires: start.Add(LEASE_DURATION).UnixMilli()}
+		if current.Leader != s.node.ID() || !current.valid(start) {
+			next.Epoch += 1
+		}
+
+		if err := s.linKV.CompareAndSwap(ctx, leaseKey(key), current, next, true); err != nil {
+			log.Printf("lease of %s at epoch %d not acquired: %v", key, next.Epoch, err)
+			return err
+		}
+
+		if next.Epoch != current.Epoch {
+			log.Printf("acquired lease of %s at epoch %d", key, next.Epoch)
+		}
+		current = next
+		return nil
+	})
+	if err != nil {
+		return lease{}, err
+	}
+
+	s.lock.Lock()
+	s.leases[key] = current
+	s.lock.Unlock()
+	return current, nil
+}
+
+func (s *KafkaSever) cachedLease(key string) (lease, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	l, ok := s.leases[key]
+	return l, ok
+}
+
+// dropLease forgets the cached lease of key so the next send reads it again.
+func (s *KafkaSever) dropLease(key string, epoch int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if l, ok := s.leases[key]; ok && l.Epoch <= epoch {
+		delete(s.leases, key)
+	}
+}
+
+// forward hands a send to the node holding the lease. Without a valid lease
+// it goes to a candidate replica, which takes the lease over, and a failing
+// candidate is skipped the next time so a crashed preferred leader doesn't
+// block the key.
+func (s *KafkaSever) forward(ctx context.Context, msg *SendMessage, l lease) (SendMessageReply, error) {
+	target := s.route(msg.Key, l)
+	reply, err := s.node.SyncRPC(ctx, target, msg)
+	if err != nil {
+		log.Printf("failed sending send message %v to %s: %v", msg, target, err)
+		return SendMessageReply{}, s.forwardFailed(msg.Key, l, target, err)
+	}
+
+	sendReply := new(SendMessageReply)
+	if err := json.Unmarshal(reply.Body, sendReply); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return SendMessageReply{}, err
+	}
+
+	return msg.Reply(sendReply.Offset), nil
+}
+
+// FromNode reports whether src is a node of the cluster rather than a
+// client, requests from nodes were forwarded already.
+func (s *KafkaSever) FromNode(src string) bool {
+	return slices.Contains(s.node.NodeIDs(), src)
+}
+
+// route returns the node sends to key go to given its lease l.
+func (s *KafkaSever) route(key string, l lease) string {
+	if !l.valid(time.Now()) {
+		return s.candidate(key)
+	}
+	return l.Leader
+}
+
+// forwardFailed forgets the route of key after forwarding to target failed
+// with err and returns the error to hand the client.
+func (s *KafkaSever) forwardFailed(key string, l lease, target string, err error) error {
+	s.dropLease(key, l.Epoch)
+	if !l.valid(time.Now()) {
+		s.skipCandidate(key)
+	}
+
+	if _, ok := err.(*maelstrom.RPCError); ok {
+		return err
+	}
+	// the request may still be applied by the leader
+	return maelstrom.NewRPCError(maelstrom.Timeout, fmt.Sprintf("request forwarded to %s timed out", target))
+}
+
+func (s *KafkaSever) candidate(key string) string {
+	replicas := s.replicas(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return replicas[s.candidates[key]%len(replicas)]
+}
+
+func (s *KafkaSever) skipCandidate(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.candidates[key] += 1
+}
+
+// fenced reports whether the lease at epoch still allows appending to p. The
+// caller holds p.lock.
+func (s *KafkaSever) fenced(key string, p *partition, epoch int) error {
+	if p.epoch <= epoch {
+		return nil
+	}
+
+	s.dropLease(key, epoch)
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("leader of %s at epoch %d fenced by epoch %d", key, epoch, p.epoch))
+}
+
+// leads reports whether this node holds a valid lease on key for the epoch p
+// leads at. The caller holds p.lock.
+func (s *KafkaSever) leads(key string, p *partition) bool {
+	l, ok := s.cachedLease(key)
+	return p.leader && ok && l.Leader == s.node.ID() && l.Epoch == p.epoch && l.valid(time.Now())
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestSendAcquiresLease(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+
+	if _, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 11}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	l := lease{}
+	if err := kafkaServer.linKV.ReadInto(ctx, leaseKey("luck"), &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Leader != "n0" || l.Epoch != 1 || !l.valid(time.Now()) {
+		t.Errorf("expected a valid lease of n0 at epoch 1 but was %v", l)
+	}
+
+	stored := logSegment{}
+	if err := kafkaServer.linKV.ReadInto(ctx, segmentKey("luck", 0), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Epoch != 1 || !slices.Equal(toMessages(stored.Records), []Message{NewMessage(0, 11)}) {
+		t.Errorf("expected log at epoch 1 with (0,11) but was %v", stored)
+	}
+}
+
+func TestSendTakesOverExpiredLease(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	kafkaServer.linKV.Write(ctx, leaseKey("luck"), lease{Leader: "n1", Epoch: 4, Expires: time.Now().Add(-time.Second).UnixMilli()})
+	kafkaServer.linKV.Write(ctx, segmentKey("luck", 0), logSegment{Epoch: 4, Records: records(NewMessage(0, 11))})
+
+	reply, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 12}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Offset != 1 {
+		t.Errorf("expected offset 1 after the previous leader's record but was %d", reply.Offset)
+	}
+
+	stored := logSegment{}
+	kafkaServer.linKV.ReadInto(ctx, segmentKey("luck", 0), &stored)
+	if stored.Epoch != 5 {
+		t.Errorf("expected log fenced at epoch 5 but was at %d", stored.Epoch)
+	}
+}
+
+func TestSendFencedByNewerEpoch(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	if _, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 11}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a successor took over while this node still holds its lease
+	kafkaServer.linKV.Write(ctx, segmentKey("luck", 0), logSegment{Epoch: 2, Records: records(NewMessage(0, 11), NewMessage(1, 12))})
+
+	_, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 13}, ctx)
+	if maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected fenced send to be temporarily unavailable but got %v", err)
+	}
+
+	stored := logSegment{}
+	kafkaServer.linKV.ReadInto(ctx, segmentKey("luck", 0), &stored)
+	if len(toMessages(stored.Records)) != 2 {
+		t.Errorf("fenced leader appended to the log %v", stored)
+	}
+
+	if _, ok := kafkaServer.cachedLease("luck"); ok {
+		t.Error("fenced leader should drop its lease")
+	}
+}
+
+func TestReplicateRejectsOlderEpoch(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	if _, err := kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", Epoch: 3, Records: records(NewMessage(0, 11)), End: 1, HighWatermark: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", Epoch: 2, Records: records(NewMessage(0, 12)), End: 1, HighWatermark: 1})
+	if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected replication from epoch 2 to be rejected but got %v", err)
+	}
+
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); !slices.Equal(messages, []Message{NewMessage(0, 11)}) {
+		t.Errorf("expected log of epoch 3 to be kept but was %v", messages)
+	}
+}
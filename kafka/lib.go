@@ -2,140 +2,559 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
+	"maps"
 	"os"
-	"strconv"
+	"path/filepath"
+	"slices"
 	"sync"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+const (
+	REPLICATION_FACTOR     int           = 3
+	MIN_IN_SYNC_REPLICAS   int           = 2
+	REPLICA_SYNC_FREQUENCY time.Duration = 200 * time.Millisecond
+	// DROP_TIMEOUT bounds cutting off a failed append, which runs once the
+	// request's deadline may have passed waiting for followers
+	DROP_TIMEOUT time.Duration = 500 * time.Millisecond
+)
+
 type KafkaSever struct {
-	log       map[string][]Message
-	logOffset map[string]int
-	lock      *sync.RWMutex
-	linKV     *maelstrom.KV
-	seqKV     *maelstrom.KV
-	node      *maelstrom.Node
+	partitions        map[string]*partition
+	lock              *sync.RWMutex
+	linKV             kvstore.Store
+	seqKV             kvstore.Store
+	node              *maelstrom.Node
+	retryPolicy       kvstore.RetryPolicy
+	freshness         kvstore.Freshness
+	replicationFactor int
+	minInSyncReplicas int
+	leases            map[string]lease
+	candidates        map[string]int
+	ring              *ring
+	ringOnce          *sync.Once
+	segments          logStorage
+	groups            map[string]*consumerGroup
+	transactions      map[string]*transaction
+	appended          chan struct{}
 }
 
 func NewKafkaSever(node *maelstrom.Node) *KafkaSever {
+	return NewKafkaSeverWithStores(node, maelstrom.NewLinKV(node), maelstrom.NewSeqKV(node), kvstore.NewBarrierFreshness(node))
+}
+
+func NewKafkaSeverWithStores(node *maelstrom.Node, linKV kvstore.Store, seqKV kvstore.Store, freshness kvstore.Freshness) *KafkaSever {
 	log.SetOutput(os.Stderr)
-	linKV := maelstrom.NewLinKV(node)
-	seqKV := maelstrom.NewSeqKV(node)
 	return &KafkaSever{
-		log:   make(map[string][]Message),
-		lock:  &sync.RWMutex{},
-		linKV: linKV,
-		seqKV: seqKV,
-		node:  node,
+		partitions:        make(map[string]*partition),
+		lock:              &sync.RWMutex{},
+		linKV:             linKV,
+		seqKV:             seqKV,
+		node:              node,
+		retryPolicy:       kvstore.DefaultRetryPolicy,
+		freshness:         freshness,
+		replicationFactor: REPLICATION_FACTOR,
+		minInSyncReplicas: MIN_IN_SYNC_REPLICAS,
+		leases:            make(map[string]lease),
+		candidates:        make(map[string]int),
+		ringOnce:          &sync.Once{},
+		segments:          newSegmentStore(linKV, SEGMENT_SIZE),
+		groups:            make(map[string]*consumerGroup),
+		transactions:      make(map[string]*transaction),
+		appended:          make(chan struct{}),
 	}
 }
 
-func (s *KafkaSever) Send(msg *SendMessage, ctx context.Context) SendMessageReply {
-	key, _ := strconv.Atoi(msg.Key)
-	targetNode := s.node.NodeIDs()[key%len(s.node.NodeIDs())]
-	if s.node.ID() != targetNode {
-		reply, err := s.node.SyncRPC(ctx, targetNode, msg)
-		if err != nil {
-			log.Printf("failed sending send message %v to %s", msg, targetNode)
-			return msg.Reply(-1)
-		}
+func (s *KafkaSever) SetReplicationFactor(replicationFactor int) {
+	s.replicationFactor = max(replicationFactor, 1)
+}
 
-		sendReply := new(SendMessageReply)
-		if err := json.Unmarshal(reply.Body, sendReply); err != nil {
-			log.Printf("failed to unmarshal message: %v", err)
-			return msg.Reply(-1)
-		}
+func (s *KafkaSever) SetMinInSyncReplicas(minInSyncReplicas int) {
+	s.minInSyncReplicas = max(minInSyncReplicas, 1)
+}
 
-		return msg.Reply(sendReply.Offset)
+// UseFileStorage keeps the logs in segment files under dir instead of lin-kv,
+// in a directory per node so nodes can share dir. Unlike lin-kv the files are
+// local to the node, followers store the records they replicate themselves.
+func (s *KafkaSever) UseFileStorage(dir string, fsync string) error {
+	store, err := newFileStore(func() string { return filepath.Join(dir, s.node.ID()) }, SEGMENT_SIZE, fsync)
+	if err != nil {
+		return err
+	}
+
+	s.segments = store
+	return nil
+}
+
+// StorageSyncer runs the periodic syncs of the file storage, if the logs are
+// kept in files.
+func (s *KafkaSever) StorageSyncer(ctx context.Context) {
+	if store, ok := s.segments.(*fileStore); ok {
+		store.Syncer(ctx)
+	}
+}
+
+// partition returns the local state of key, creating it when create is set.
+func (s *KafkaSever) partition(key string, create bool) (*partition, bool) {
+	s.lock.RLock()
+	p, ok := s.partitions[key]
+	s.lock.RUnlock()
+	if ok || !create {
+		return p, ok
 	}
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	messages := s.log[msg.Key]
-	if messages == nil {
-		messages = make([]Message, 0)
+	if p, ok := s.partitions[key]; ok {
+		return p, true
+	}
+
+	p = newPartition()
+	s.partitions[key] = p
+	return p, true
+}
+
+func (s *KafkaSever) Send(msg *SendMessage, ctx context.Context) (SendMessageReply, error) {
+	if msg.Control != "" {
+		return SendMessageReply{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, "transaction markers can't be sent")
+	}
+
+	l, err := s.leaderFor(ctx, msg.Key)
+	if err != nil {
+		log.Printf("failed to find leader of %s: %v", msg.Key, err)
+		return SendMessageReply{}, err
+	}
+
+	if l.Leader != s.node.ID() || !l.valid(time.Now()) {
+		return s.forward(ctx, msg, l)
 	}
 
-	modifiedMessage := append(messages, NewMessage(len(messages), msg.Value))
-	err := s.linKV.CompareAndSwap(ctx, msg.Key, messages, modifiedMessage, true)
-	if err == nil {
-		s.log[msg.Key] = modifiedMessage
-		return msg.Reply(len(messages))
+	if msg.TransactionalID != "" {
+		if err := s.addToTxn(ctx, msg); err != nil {
+			log.Printf("failed to add %s to transaction %s: %v", msg.Key, msg.TransactionalID, err)
+			return SendMessageReply{}, err
+		}
+	}
+
+	followers := s.followers(msg.Key)
+	record := Record{
+		Value:             msg.Value,
+		Text:              msg.Text,
+		Bytes:             msg.Bytes,
+		Headers:           msg.Headers,
+		ProducerTimestamp: msg.Timestamp,
+		SubKey:            msg.SubKey,
+		ProducerID:        msg.ProducerID,
+		Seq:               msg.Seq,
+		TransactionalID:   msg.TransactionalID,
+		TxnEpoch:          msg.TxnEpoch,
+	}
+	offset, err := s.appendAsLeader(ctx, msg.Key, l.Epoch, followers, record)
+	if err != nil {
+		log.Printf("failed to append %d to %s: %v", msg.Value, msg.Key, err)
+		return SendMessageReply{}, err
 	}
 
-	return msg.Reply(-1)
+	return msg.Reply(offset), nil
 }
 
-func (s *KafkaSever) Poll(msg *PollMessage, ctx context.Context) PollMessageReply {
+// appendAsLeader appends record at the end of the log, assigning its offset
+// and timestamp. A retried send of an idempotent producer gets the offset it
+// was appended at.
+func (s *KafkaSever) appendAsLeader(ctx context.Context, key string, epoch int, followers []string, record Record) (int, error) {
+	p, _ := s.partition(key, true)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := s.fenced(key, p, epoch); err != nil {
+		return 0, err
+	}
+	p.lead(epoch, followers)
+
+	offset := 0
+	// errors retrying won't help with
+	var rejected error
+	err := kvstore.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+		// checked on every attempt, a reload may bring in the earlier send
+		if record.ProducerID != "" {
+			appended, duplicate, err := p.checkSequence(record.ProducerID, record.Seq)
+			if err != nil || duplicate {
+				offset, rejected = appended, err
+				return nil
+			}
+		}
+		if rejected = p.checkTxn(record); rejected != nil {
+			return nil
+		}
+
+		offset = p.log.end
+		// append timestamps never go backwards so they can be searched
+		record.Offset, record.Timestamp = offset, max(time.Now().UnixMilli(), p.log.lastTimestamp())
+		err := s.segments.Append(ctx, key, p.log, p.storedEpoch, epoch, record)
+		if err == nil {
+			p.log.append([]Record{record}, offset+1)
+			p.observeProducers([]Record{record})
+			p.storedEpoch = epoch
+			return nil
+		}
+
+		if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+			// our cached log is behind lin-kv, e.g. after a restart or a
+			// change of leader
+			if err := s.reload(ctx, key, p); err != nil {
+				return kvstore.Unavailable(err)
+			}
+			if rejected = s.fenced(key, p, epoch); rejected != nil {
+				// a newer leader owns the key
+				p.leader = false
+				return nil
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if rejected != nil {
+		return 0, rejected
+	}
+
+	if err := s.replicate(ctx, key, p); err != nil {
+		log.Printf("failed to replicate %d of %s: %v", offset, key, err)
+		return 0, s.dropUnreplicated(ctx, key, p, epoch, offset, err)
+	}
+	return offset, nil
+}
+
+// dropUnreplicated cuts the log of key back to the high-water mark after the
+// append at offset failed to replicate with err, so the failed append is
+// never handed out. If the records can't be cut off, or the leader stepped
+// down and the next leader decides whether to keep them, the outcome of the
+// append is unknown. The caller holds p.lock.
+func (s *KafkaSever) dropUnreplicated(ctx context.Context, key string, p *partition, epoch int, offset int, err error) error {
+	if !p.leader {
+		return maelstrom.NewRPCError(maelstrom.Timeout, fmt.Sprintf("append of %d to %s has an unknown outcome: %v", offset, key, err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DROP_TIMEOUT)
+	defer cancel()
+	if _, cutErr := s.cutLog(ctx, key, p, epoch, p.highWatermark); cutErr != nil {
+		log.Printf("failed to drop unreplicated records of %s from %d: %v", key, p.highWatermark, cutErr)
+		return maelstrom.NewRPCError(maelstrom.Timeout, fmt.Sprintf("append of %d to %s has an unknown outcome: %v", offset, key, err))
+	}
+	return err
+}
+
+// reload brings the cached log of key up to date with the tail segments in
+// the storage. The caller holds p.lock.
+func (s *KafkaSever) reload(ctx context.Context, key string, p *partition) error {
+	stored, storedEpoch, err := s.segments.Read(ctx, key, p.log.end)
+	if err != nil {
+		return err
+	}
+
+	p.log.truncate(s.segments.segmentStart(p.log.end))
+	if stored.start > p.log.end {
+		// retention removed the records we are missing
+		p.log.reset(stored.start)
+	}
+	p.log.append(stored.from(p.log.end), stored.end)
+	p.log.trimHead(stored.start)
+	p.rebuildProducers()
+	p.storedEpoch = storedEpoch
+	p.epoch = max(p.epoch, storedEpoch)
+	return nil
+}
+
+// Poll blocks for up to wait_ms until any of the polled keys has records past
+// its offset. ctx has to outlive the wait, see PollMessage.Wait.
+func (s *KafkaSever) Poll(msg *PollMessage, ctx context.Context) (PollMessageReply, error) {
+	deadline := time.Now().Add(msg.Wait())
+	for {
+		// taken before polling so records appended meanwhile wake us up
+		appended := s.appendedSignal()
+		reply, err := s.poll(msg, ctx)
+		if err != nil || reply.hasMessages() || !time.Now().Before(deadline) {
+			return reply, err
+		}
+
+		if !waitForRecords(ctx, appended, deadline) {
+			return reply, nil
+		}
+	}
+}
+
+func (s *KafkaSever) poll(msg *PollMessage, ctx context.Context) (PollMessageReply, error) {
 	messages := make(map[string][]Message)
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	for key, offset := range msg.Offsets {
-		logMessages, ok := s.log[key]
-		if !ok {
-			err := s.linKV.ReadInto(ctx, key, &logMessages)
+	nextOffsets := make(Offsets)
+	logStartOffsets := make(Offsets)
+	records := make(map[string][]PolledRecord)
+	limits := newPollLimits(msg.MaxMessages, msg.MaxBytes, msg.Format)
+	for _, key := range slices.Sorted(maps.Keys(msg.Offsets)) {
+		offset := msg.Offsets[key]
+		l, owner, err := s.readableLog(ctx, key, msg.Forwarded)
+		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+			log.Printf("key: %s not found in logs", key)
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to poll %s: %v", key, err)
+			return PollMessageReply{}, err
+		}
 
-			if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
-				log.Printf("key: %s not found in logs", key)
+		if owner.Leader != s.node.ID() {
+			if limits.exhausted() {
 				continue
 			}
 
+			forwarded, err := s.forwardPoll(ctx, msg, key, owner, limits)
 			if err != nil {
-				log.Printf("error occurred while fetching %s", key)
-				continue
+				log.Printf("failed to poll %s from %s: %v", key, owner.Leader, err)
+				return PollMessageReply{}, err
+			}
+			if polled, ok := forwarded.Messages[key]; ok {
+				messages[key] = polled
+			}
+			if polled, ok := forwarded.Records[key]; ok {
+				records[key] = polled
+			}
+			if next, ok := forwarded.NextOffsets[key]; ok {
+				nextOffsets[key] = next
 			}
+			if start, ok := forwarded.LogStartOffsets[key]; ok {
+				logStartOffsets[key] = start
+			}
+			continue
 		}
 
-		if len(logMessages) < offset {
-			log.Printf("size of key: %s was %d which is less than offset %d", key, len(logMessages), offset)
+		if l.end < offset {
+			log.Printf("size of key: %s was %d which is less than offset %d", key, l.end, offset)
 			continue
 		}
 
-		messages[key] = logMessages[offset:]
+		if offset < l.start {
+			// the consumer fell behind retention
+			logStartOffsets[key] = l.start
+			offset = l.start
+		}
+
+		visible, end := l.from(offset), l.end
+		if msg.IsolationLevel == READ_COMMITTED {
+			visible, end = readCommitted(l, visible)
+		} else {
+			visible = readUncommitted(visible)
+		}
+
+		taken := limits.take(visible)
+		if msg.Format == RECORDS_FORMAT {
+			records[key] = toPolledRecords(visible[:taken])
+		} else {
+			messages[key] = toMessages(visible[:taken])
+		}
+		nextOffsets[key] = end
+		if taken < len(visible) {
+			nextOffsets[key] = visible[taken].Offset
+		}
 	}
 
-	return PollMessageReply{MessageType: "poll_ok", Messages: messages}
+	reply := PollMessageReply{MessageType: "poll_ok", Messages: messages, NextOffsets: nextOffsets}
+	if len(logStartOffsets) > 0 {
+		reply.LogStartOffsets = logStartOffsets
+	}
+	if msg.Format == RECORDS_FORMAT {
+		reply.Records = records
+	}
+	return reply, nil
 }
 
-func (s *KafkaSever) CommitOffsets(msg *CommitOffsets, ctx context.Context) CommitOffsetsReply {
-	for key, offset := range msg.Offsets {
-		for {
-			existingOffset, err := s.seqKV.ReadInt(ctx, key)
-			if existingOffset > offset {
-				log.Printf("existing offset %d greater than update %s:%d", existingOffset, key, offset)
-				break
-			}
+// forwardPoll polls key from the node owner routes to, within what is left
+// of limits, and counts the records it handed out against them.
+func (s *KafkaSever) forwardPoll(ctx context.Context, msg *PollMessage, key string, owner lease, limits *pollLimits) (PollMessageReply, error) {
+	forwarded := &PollMessage{
+		MessageType:    "poll",
+		Offsets:        Offsets{key: msg.Offsets[key]},
+		IsolationLevel: msg.IsolationLevel,
+		Format:         msg.Format,
+	}
+	forwarded.MaxMessages, forwarded.MaxBytes = limits.remaining()
 
-			if err != nil || maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
-				log.Printf("error while trying to read value of %s: %v", key, err)
-				break
-			}
+	reply, err := forwardToLeader[PollMessageReply](ctx, s, key, owner, forwarded)
+	if err != nil {
+		return PollMessageReply{}, err
+	}
+	limits.count(reply.Messages[key], reply.Records[key])
+	return reply, nil
+}
 
-			err = s.seqKV.CompareAndSwap(ctx, key, existingOffset, offset, true)
-			if err == nil {
-				log.Printf("offset update %s:%d", key, offset)
-				break
-			}
+// readableLog returns the log of key polls may hand out, the log up to the
+// high-water mark. A replica without the log takes over the key's lease and
+// leads it from the stored log, which brings the in-sync replicas up to date
+// and with it the high-water mark. Otherwise the returned lease routes to the
+// node to read the log from, unless the read was forwarded already. It fails
+// with KeyDoesNotExist for unknown keys.
+func (s *KafkaSever) readableLog(ctx context.Context, key string, forwarded bool) (recordLog, lease, error) {
+	if l, ok := s.replicatedLog(key); ok {
+		return l, lease{Leader: s.node.ID()}, nil
+	}
 
-			if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
-				continue
+	owner, err := s.leaderFor(ctx, key)
+	if err != nil {
+		return recordLog{}, lease{}, err
+	}
+
+	if owner.Leader == s.node.ID() && owner.valid(time.Now()) {
+		l, err := s.leadToRead(ctx, key, owner.Epoch)
+		return l, owner, err
+	}
+	if forwarded || s.route(key, owner) == s.node.ID() {
+		return recordLog{}, lease{}, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("%s doesn't hold %s", s.node.ID(), key))
+	}
+	return recordLog{}, owner, nil
+}
+
+// leadToRead makes this node, holding the lease of key at epoch, lead the key
+// from its stored log and returns the log up to the high-water mark.
+func (s *KafkaSever) leadToRead(ctx context.Context, key string, epoch int) (recordLog, error) {
+	p, _ := s.partition(key, true)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !s.leads(key, p) {
+		if err := s.reload(ctx, key, p); err != nil {
+			return recordLog{}, err
+		}
+		if err := s.fenced(key, p, epoch); err != nil {
+			return recordLog{}, err
+		}
+
+		p.lead(epoch, s.followers(key))
+		if err := s.replicate(ctx, key, p); err != nil {
+			return recordLog{}, err
+		}
+	}
+
+	if p.log.end == 0 {
+		return recordLog{}, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, fmt.Sprintf("%s is empty", key))
+	}
+	return p.log.upTo(p.highWatermark), nil
+}
+
+// OffsetsForTimes looks up the first offset of every key appended at or
+// after the requested timestamp, the log end when every record is older.
+// Unknown keys are left out, keys held by other nodes are looked up there.
+func (s *KafkaSever) OffsetsForTimes(msg *OffsetsForTimesMessage, ctx context.Context) (OffsetsForTimesMessageReply, error) {
+	offsets := make(Offsets)
+	for key, timestamp := range msg.Timestamps {
+		l, owner, err := s.readableLog(ctx, key, msg.Forwarded)
+		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to read %s looking up offset for %d: %v", key, timestamp, err)
+			return OffsetsForTimesMessageReply{}, err
+		}
+
+		if owner.Leader != s.node.ID() {
+			forwarded := &OffsetsForTimesMessage{MessageType: "offsets_for_times", Timestamps: map[string]int64{key: timestamp}}
+			reply, err := forwardToLeader[OffsetsForTimesMessageReply](ctx, s, key, owner, forwarded)
+			if err != nil {
+				return OffsetsForTimesMessageReply{}, err
+			}
+			if offset, ok := reply.Offsets[key]; ok {
+				offsets[key] = offset
 			}
+			continue
+		}
 
-			log.Printf("failed to update offset for %s to %d due to error %v", key, offset, err)
-			break
+		offsets[key] = l.offsetForTime(timestamp)
+	}
+
+	return msg.Reply(offsets), nil
+}
+
+func (s *KafkaSever) CommitOffsets(msg *CommitOffsets, ctx context.Context) (CommitOffsetsReply, error) {
+	if msg.Group != "" {
+		return s.commitGroupOffsets(msg, ctx)
+	}
+
+	for key, offset := range msg.Offsets {
+		if err := s.commitOffset(ctx, key, offset); err != nil {
+			return CommitOffsetsReply{}, err
 		}
 	}
 
-	return msg.Reply()
+	return msg.Reply(), nil
+}
+
+// commitGroupOffsets commits through the group's coordinator, which holds the
+// group's lock while committing so a rebalance can't interleave.
+func (s *KafkaSever) commitGroupOffsets(msg *CommitOffsets, ctx context.Context) (CommitOffsetsReply, error) {
+	l, ok, err := s.coordinatorFor(ctx, groupKey(msg.Group))
+	if err != nil {
+		return CommitOffsetsReply{}, err
+	}
+	if !ok {
+		return forwardToLeader[CommitOffsetsReply](ctx, s, groupKey(msg.Group), l, msg)
+	}
+
+	g, err := s.group(ctx, msg.Group, l.Epoch)
+	if err != nil {
+		return CommitOffsetsReply{}, err
+	}
+	defer g.lock.Unlock()
+
+	if err := g.validateCommit(msg); err != nil {
+		log.Printf("rejected commit of %v: %v", msg.Offsets, err)
+		return CommitOffsetsReply{}, err
+	}
+
+	for key, offset := range msg.Offsets {
+		if err := s.commitOffset(ctx, offsetKey(msg.Group, key), offset); err != nil {
+			return CommitOffsetsReply{}, err
+		}
+	}
+
+	return msg.Reply(), nil
+}
+
+func (s *KafkaSever) commitOffset(ctx context.Context, key string, offset int) error {
+	err := kvstore.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+		existingOffset, err := s.seqKV.ReadInt(ctx, key)
+		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			log.Printf("error while trying to read value of %s: %v", key, err)
+			return kvstore.Unavailable(err)
+		}
+
+		if err == nil && existingOffset >= offset {
+			log.Printf("existing offset %d not less than update %s:%d", existingOffset, key, offset)
+			return nil
+		}
+
+		if err := s.seqKV.CompareAndSwap(ctx, key, existingOffset, offset, true); err != nil {
+			return err
+		}
+
+		s.freshness.Observe(key, offset)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to update offset for %s to %d due to error %v", key, offset, err)
+		return err
+	}
+
+	log.Printf("offset update %s:%d", key, offset)
+	return nil
 }
 
 func (s *KafkaSever) ListCommitedOffsets(msg *ListCommittedOffsets, ctx context.Context) ListCommittedOffsetsReply {
 	offsets := make(map[string]int)
 	for _, key := range msg.Keys {
-		offset, err := s.seqKV.ReadInt(ctx, key)
+		offset, err := kvstore.ReadInt(ctx, s.freshness, s.seqKV, offsetKey(msg.Group, key))
 		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
 			log.Printf("log offset not found of key:%s", key)
 			continue
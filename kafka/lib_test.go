@@ -6,16 +6,47 @@ import (
 	"slices"
 	"testing"
 
+	kvstore "gossip-glomers/kv-store"
+
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
-func TestKafkaSend(t *testing.T) {
+func newTestKafkaServer(seqKV kvstore.Store) *KafkaSever {
 	node := maelstrom.NewNode()
-	kafkaServer := NewKafkaSever(node)
+	node.Init("n0", []string{"n0"})
+	return NewKafkaSeverWithStores(node, kvstore.NewMemoryStore(), seqKV, kvstore.NewBarrierFreshness(node))
+}
+
+// loadPartition stores messages as a fully replicated log of key
+func loadPartition(s *KafkaSever, key string, messages []Message) {
+	p, _ := s.partition(key, true)
+	p.log = recordLog{records: records(messages...), end: len(messages)}
+	p.highWatermark = len(messages)
+}
+
+func records(messages ...Message) []Record {
+	records := make([]Record, 0, len(messages))
+	for _, message := range messages {
+		records = append(records, Record{Offset: message.offset(), Value: message.value()})
+	}
+	return records
+}
+
+// replicatedMessages returns the messages of key up to the high-water mark
+func replicatedMessages(s *KafkaSever, key string) ([]Message, bool) {
+	l, ok := s.replicatedLog(key)
+	return toMessages(l.records), ok
+}
+
+func TestKafkaSend(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
 	sendMessage := SendMessage{MessageType: "send", Value: 11, Key: "luck"}
 	ctx := context.TODO()
 
-	reply := kafkaServer.Send(&sendMessage, ctx)
+	reply, err := kafkaServer.Send(&sendMessage, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if reply.MessageType != "send_ok" {
 		t.Errorf("expected reply of type 'send_ok' but was %s", reply.MessageType)
@@ -25,7 +56,7 @@ func TestKafkaSend(t *testing.T) {
 		t.Errorf("initial offset should be 0 but was %d", reply.Offset)
 	}
 
-	if messages, ok := kafkaServer.log["luck"]; !ok {
+	if messages, ok := replicatedMessages(kafkaServer, "luck"); !ok {
 		t.Error("key 'luck' not found in server logs")
 	} else {
 		if len(messages) != 1 {
@@ -38,14 +69,13 @@ func TestKafkaSend(t *testing.T) {
 }
 
 func TestKafkaPoll(t *testing.T) {
-	node := maelstrom.NewNode()
-	kafkaServer := NewKafkaSever(node)
-	kafkaServer.log["luck"] = []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)}
-	kafkaServer.log["prize"] = []Message{NewMessage(0, 1), NewMessage(1, 4)}
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	loadPartition(kafkaServer, "luck", []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)})
+	loadPartition(kafkaServer, "prize", []Message{NewMessage(0, 1), NewMessage(1, 4)})
 	pollMessage := PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0, "prize": 1}}
 	ctx := context.TODO()
 
-	reply := kafkaServer.Poll(&pollMessage, ctx)
+	reply, _ := kafkaServer.Poll(&pollMessage, ctx)
 
 	if reply.MessageType != "poll_ok" {
 		t.Errorf("incorrect message type of %s expected 'poll_ok'", reply.MessageType)
@@ -61,36 +91,39 @@ func TestKafkaPoll(t *testing.T) {
 }
 
 func TestKafkaCommitOffsets(t *testing.T) {
-	node := maelstrom.NewNode()
-	kafkaServer := NewKafkaSever(node)
-	kafkaServer.log["luck"] = []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)}
-	kafkaServer.log["prize"] = []Message{NewMessage(0, 1), NewMessage(1, 4)}
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	loadPartition(kafkaServer, "luck", []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)})
+	loadPartition(kafkaServer, "prize", []Message{NewMessage(0, 1), NewMessage(1, 4)})
 	commitOffsetsMessage := CommitOffsets{MessageType: "commit_offsets", Offsets: Offsets{
 		"luck":  2,
 		"prize": 1,
 	}}
 	ctx := context.TODO()
 
-	reply := kafkaServer.CommitOffsets(&commitOffsetsMessage, ctx)
+	reply, err := kafkaServer.CommitOffsets(&commitOffsetsMessage, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if reply.MessageType != "commit_offsets_ok" {
 		t.Errorf("expected message of type 'commit_offsets_ok' but was %s", reply.MessageType)
 	}
 
-	if kafkaServer.logOffset["luck"] != 2 || kafkaServer.logOffset["prize"] != 1 {
-		t.Errorf("luck:%d expected:2, prize:%d expected:1", kafkaServer.logOffset["luck"], kafkaServer.logOffset["prize"])
+	luck, _ := kafkaServer.seqKV.ReadInt(ctx, "luck")
+	prize, _ := kafkaServer.seqKV.ReadInt(ctx, "prize")
+	if luck != 2 || prize != 1 {
+		t.Errorf("luck:%d expected:2, prize:%d expected:1", luck, prize)
 	}
 }
 
 func TestListCommittedOffsets(t *testing.T) {
-	node := maelstrom.NewNode()
-	kafkaServer := NewKafkaSever(node)
-	kafkaServer.log["luck"] = []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)}
-	kafkaServer.log["prize"] = []Message{NewMessage(0, 1), NewMessage(1, 4)}
-	kafkaServer.logOffset["luck"] = 2
-	kafkaServer.logOffset["prize"] = 1
-	listCommittedOffsets := ListCommittedOffsets{MessageType: "list_committed_offsets", Keys: []string{"luck", "nozzle", "prize"}}
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	loadPartition(kafkaServer, "luck", []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)})
+	loadPartition(kafkaServer, "prize", []Message{NewMessage(0, 1), NewMessage(1, 4)})
 	ctx := context.TODO()
+	kafkaServer.seqKV.Write(ctx, "luck", 2)
+	kafkaServer.seqKV.Write(ctx, "prize", 1)
+	listCommittedOffsets := ListCommittedOffsets{MessageType: "list_committed_offsets", Keys: []string{"luck", "nozzle", "prize"}}
 
 	reply := kafkaServer.ListCommitedOffsets(&listCommittedOffsets, ctx)
 
@@ -102,3 +135,43 @@ func TestListCommittedOffsets(t *testing.T) {
 		t.Errorf("expected values map[luck:2, prize:1] but found %v", reply.Offsets)
 	}
 }
+
+func TestListCommittedOffsetsFromStaleSeqKV(t *testing.T) {
+	seqKV := kvstore.NewStaleStore()
+	committer := newTestKafkaServer(seqKV.Client())
+	lister := newTestKafkaServer(seqKV.Client())
+	ctx := context.TODO()
+
+	for offset := range 3 {
+		if _, err := committer.CommitOffsets(&CommitOffsets{MessageType: "commit_offsets", Offsets: Offsets{"luck": offset + 1}}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reply := lister.ListCommitedOffsets(&ListCommittedOffsets{MessageType: "list_committed_offsets", Keys: []string{"luck"}}, ctx)
+
+	if reply.Offsets["luck"] != 3 {
+		t.Errorf("expected committed offset 3 but listed %v", reply.Offsets)
+	}
+}
+
+func TestOffsetsForTimes(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	p, _ := kafkaServer.partition("luck", true)
+	// compaction left a gap at offset 2
+	p.log = recordLog{records: []Record{{Offset: 0, Timestamp: 100}, {Offset: 1, Timestamp: 200}, {Offset: 3, Timestamp: 300}}, end: 4}
+	p.highWatermark = 4
+
+	expected := map[int64]int{50: 0, 100: 0, 150: 1, 250: 3, 301: 4}
+	for timestamp, offset := range expected {
+		reply, _ := kafkaServer.OffsetsForTimes(&OffsetsForTimesMessage{MessageType: "offsets_for_times", Timestamps: map[string]int64{"luck": timestamp, "missing": timestamp}}, ctx)
+		if reply.Offsets["luck"] != offset {
+			t.Errorf("expected offset %d for %d but got %d", offset, timestamp, reply.Offsets["luck"])
+		}
+
+		if _, ok := reply.Offsets["missing"]; ok {
+			t.Errorf("expected unknown keys to be left out but got %v", reply.Offsets)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package kafka
+
+import (
+	"slices"
+	"sort"
+)
+
+// recordLog holds the records of a key from start up to end sorted by
+// offset. Records removed by compaction leave gaps, so records are looked up
+// by offset instead of by index.
+type recordLog struct {
+	records []Record
+	start   int
+	end     int
+}
+
+func newRecordLog() recordLog {
+	return recordLog{records: make([]Record, 0)}
+}
+
+// index returns the position of the first record at or after offset.
+func (l recordLog) index(offset int) int {
+	return sort.Search(len(l.records), func(i int) bool { return l.records[i].Offset >= offset })
+}
+
+// from returns the records at or after offset.
+func (l recordLog) from(offset int) []Record {
+	return l.records[l.index(offset):]
+}
+
+// upTo returns the log without the records at or after offset.
+func (l recordLog) upTo(offset int) recordLog {
+	offset = min(offset, l.end)
+	return recordLog{records: l.records[:l.index(offset)], start: min(l.start, offset), end: offset}
+}
+
+// truncate drops the records at or after offset. The records are clipped so
+// slices handed out to polls aren't overwritten by later appends.
+func (l *recordLog) truncate(offset int) {
+	l.records = slices.Clip(l.records[:l.index(offset)])
+	l.end = min(l.end, offset)
+	l.start = min(l.start, l.end)
+}
+
+// append adds records that follow the log and moves its end to end, which
+// is past the last record if the leader compacted away the records before it.
+func (l *recordLog) append(records []Record, end int) {
+	l.records = append(l.records, records...)
+	l.end = max(l.end, end)
+}
+
+// trimHead drops the records before start.
+func (l *recordLog) trimHead(start int) {
+	if start <= l.start {
+		return
+	}
+
+	l.records = l.records[l.index(start):]
+	l.start = min(start, l.end)
+}
+
+// reset empties the log and starts it at offset, records before it are gone
+// from the leader.
+func (l *recordLog) reset(offset int) {
+	l.records = make([]Record, 0)
+	l.start, l.end = offset, offset
+}
+
+// compact drops every record before offset that has a sub key and is
+// followed by a newer record with the same sub key before offset. Records
+// without a sub key are left to retention. It returns whether any record was
+// dropped.
+func (l *recordLog) compact(offset int) bool {
+	latest := make(map[string]int)
+	for _, record := range l.records[:l.index(offset)] {
+		if record.SubKey != "" {
+			latest[record.SubKey] = record.Offset
+		}
+	}
+
+	compacted := make([]Record, 0, len(l.records))
+	for _, record := range l.records {
+		if record.Offset < offset && record.SubKey != "" && latest[record.SubKey] != record.Offset {
+			continue
+		}
+		compacted = append(compacted, record)
+	}
+
+	if len(compacted) == len(l.records) {
+		return false
+	}
+
+	// a new slice, polls may still be reading the old one
+	l.records = compacted
+	return true
+}
+
+// lastTimestamp returns the append timestamp of the last record, zero for an
+// empty log.
+func (l recordLog) lastTimestamp() int64 {
+	if len(l.records) == 0 {
+		return 0
+	}
+	return l.records[len(l.records)-1].Timestamp
+}
+
+// offsetForTime returns the offset of the first record appended at or after
+// timestamp, or the log end if there is none. Append timestamps never go
+// backwards, so the records are binary searched.
+func (l recordLog) offsetForTime(timestamp int64) int {
+	i := sort.Search(len(l.records), func(i int) bool { return l.records[i].Timestamp >= timestamp })
+	if i == len(l.records) {
+		return l.end
+	}
+	return l.records[i].Offset
+}
+
+func toMessages(records []Record) []Message {
+	messages := make([]Message, 0, len(records))
+	for _, record := range records {
+		messages = append(messages, record.Message())
+	}
+	return messages
+}
+
+func toPolledRecords(records []Record) []PolledRecord {
+	polled := make([]PolledRecord, 0, len(records))
+	for _, record := range records {
+		polled = append(polled, record.Polled())
+	}
+	return polled
+}
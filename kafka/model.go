@@ -4,6 +4,21 @@ type SendMessage struct {
 	MessageType string `json:"type"`
 	Key         string `json:"key"`
 	Value       int    `json:"msg"`
+	SubKey      string `json:"sub_key,omitempty"`
+	ProducerID  string `json:"producer_id,omitempty"`
+	Seq         int    `json:"seq,omitempty"`
+	// Text and Bytes are values other than the integer msg of the challenge,
+	// Timestamp is the producer's unix milliseconds of the send.
+	Text      string            `json:"text,omitempty"`
+	Bytes     []byte            `json:"bytes,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	// TransactionalID and TxnEpoch tie the send to an open transaction.
+	// Control is only decoded to reject sends forging transaction markers,
+	// coordinators write them with a WriteMarkerMessage.
+	TransactionalID string `json:"transactional_id,omitempty"`
+	TxnEpoch        int    `json:"txn_epoch,omitempty"`
+	Control         string `json:"control,omitempty"`
 }
 
 type SendMessageReply struct {
@@ -26,6 +41,56 @@ func NewMessage(offset int, value int) Message {
 	return [2]int{offset, value}
 }
 
+// Record is a message as it is stored, Timestamp is the unix milliseconds
+// the leader appended it at, never before the record ahead of it, and
+// ProducerTimestamp the one the producer sent. SubKey is the key compaction
+// keeps the latest record of. ProducerID and Seq identify sends of idempotent producers.
+// Records written in a transaction carry its TransactionalID and TxnEpoch,
+// control records are the commit or abort markers of a transaction and are
+// never handed to consumers.
+type Record struct {
+	Offset            int               `json:"offset"`
+	Value             int               `json:"value"`
+	Text              string            `json:"text,omitempty"`
+	Bytes             []byte            `json:"bytes,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Timestamp         int64             `json:"ts"`
+	ProducerTimestamp int64             `json:"producer_ts,omitempty"`
+	SubKey            string            `json:"sub_key,omitempty"`
+	ProducerID        string            `json:"producer_id,omitempty"`
+	Seq               int               `json:"seq,omitempty"`
+	TransactionalID   string            `json:"txn_id,omitempty"`
+	TxnEpoch          int               `json:"txn_epoch,omitempty"`
+	Control           string            `json:"control,omitempty"`
+}
+
+func (r Record) Message() Message {
+	return NewMessage(r.Offset, r.Value)
+}
+
+// PolledRecord is a record as polls in the RECORDS_FORMAT hand it out.
+type PolledRecord struct {
+	Offset            int               `json:"offset"`
+	Value             int               `json:"msg"`
+	Text              string            `json:"text,omitempty"`
+	Bytes             []byte            `json:"bytes,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Timestamp         int64             `json:"timestamp"`
+	ProducerTimestamp int64             `json:"producer_timestamp,omitempty"`
+}
+
+func (r Record) Polled() PolledRecord {
+	return PolledRecord{
+		Offset:            r.Offset,
+		Value:             r.Value,
+		Text:              r.Text,
+		Bytes:             r.Bytes,
+		Headers:           r.Headers,
+		Timestamp:         r.Timestamp,
+		ProducerTimestamp: r.ProducerTimestamp,
+	}
+}
+
 func (m *Message) offset() int {
 	return m[0]
 }
@@ -37,16 +102,48 @@ func (m *Message) value() int {
 type PollMessage struct {
 	MessageType string  `json:"type"`
 	Offsets     Offsets `json:"offsets"`
+	MaxMessages int     `json:"max_messages,omitempty"`
+	MaxBytes    int     `json:"max_bytes,omitempty"`
+	WaitMs      int     `json:"wait_ms,omitempty"`
+	// IsolationLevel is READ_COMMITTED to only see committed transactions
+	IsolationLevel string `json:"isolation_level,omitempty"`
+	// Format is RECORDS_FORMAT to get whole records instead of messages
+	Format string `json:"format,omitempty"`
+	// Forwarded is set by the handler on polls another node forwarded, which
+	// are answered locally
+	Forwarded bool `json:"-"`
 }
 
 type PollMessageReply struct {
-	MessageType string               `json:"type"`
-	Messages    map[string][]Message `json:"msgs"`
+	MessageType     string               `json:"type"`
+	Messages        map[string][]Message `json:"msgs"`
+	NextOffsets     Offsets              `json:"next_offsets"`
+	LogStartOffsets Offsets              `json:"log_start_offsets,omitempty"`
+	// Records replaces Messages in polls of the RECORDS_FORMAT, which then
+	// hand out no msgs
+	Records map[string][]PolledRecord `json:"records,omitempty"`
+}
+
+func (r PollMessageReply) hasMessages() bool {
+	for _, messages := range r.Messages {
+		if len(messages) > 0 {
+			return true
+		}
+	}
+	for _, records := range r.Records {
+		if len(records) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 type CommitOffsets struct {
 	MessageType string  `json:"type"`
 	Offsets     Offsets `json:"offsets"`
+	Group       string  `json:"group,omitempty"`
+	MemberID    string  `json:"member_id,omitempty"`
+	Generation  int     `json:"generation,omitempty"`
 }
 
 type CommitOffsetsReply struct {
@@ -60,9 +157,332 @@ func (co *CommitOffsets) Reply() CommitOffsetsReply {
 type ListCommittedOffsets struct {
 	MessageType string   `json:"type"`
 	Keys        []string `json:"keys"`
+	Group       string   `json:"group,omitempty"`
 }
 
 type ListCommittedOffsetsReply struct {
 	MessageType string  `json:"type"`
 	Offsets     Offsets `json:"offsets"`
 }
+
+type ReplicateMessage struct {
+	MessageType   string   `json:"type"`
+	Key           string   `json:"key"`
+	Epoch         int      `json:"epoch"`
+	From          int      `json:"from"`
+	Records       []Record `json:"records"`
+	End           int      `json:"end"`
+	HighWatermark int      `json:"high_watermark"`
+	LogStart      int      `json:"log_start"`
+	CompactedTo   int      `json:"compacted_to"`
+}
+
+type ReplicateMessageReply struct {
+	MessageType string `json:"type"`
+	LogEnd      int    `json:"log_end"`
+}
+
+func (m *ReplicateMessage) Reply(logEnd int) ReplicateMessageReply {
+	return ReplicateMessageReply{MessageType: "replicate_ok", LogEnd: logEnd}
+}
+
+type OwnerMessage struct {
+	MessageType string `json:"type"`
+	Key         string `json:"key"`
+}
+
+type OwnerMessageReply struct {
+	MessageType string   `json:"type"`
+	Key         string   `json:"key"`
+	Leader      string   `json:"leader"`
+	Replicas    []string `json:"replicas"`
+}
+
+func (m *OwnerMessage) Reply(leader string, replicas []string) OwnerMessageReply {
+	return OwnerMessageReply{MessageType: "owner_ok", Key: m.Key, Leader: leader, Replicas: replicas}
+}
+
+type JoinGroupMessage struct {
+	MessageType string   `json:"type"`
+	Group       string   `json:"group"`
+	MemberID    string   `json:"member_id,omitempty"`
+	Keys        []string `json:"keys"`
+}
+
+type JoinGroupMessageReply struct {
+	MessageType string   `json:"type"`
+	MemberID    string   `json:"member_id"`
+	Generation  int      `json:"generation"`
+	Assignment  []string `json:"assignment"`
+}
+
+func (m *JoinGroupMessage) Reply(memberID string, generation int, assignment []string) JoinGroupMessageReply {
+	return JoinGroupMessageReply{MessageType: "join_group_ok", MemberID: memberID, Generation: generation, Assignment: assignment}
+}
+
+type HeartbeatMessage struct {
+	MessageType string `json:"type"`
+	Group       string `json:"group"`
+	MemberID    string `json:"member_id"`
+}
+
+type HeartbeatMessageReply struct {
+	MessageType string   `json:"type"`
+	Generation  int      `json:"generation"`
+	Assignment  []string `json:"assignment"`
+}
+
+func (m *HeartbeatMessage) Reply(generation int, assignment []string) HeartbeatMessageReply {
+	return HeartbeatMessageReply{MessageType: "heartbeat_ok", Generation: generation, Assignment: assignment}
+}
+
+type LeaveGroupMessage struct {
+	MessageType string `json:"type"`
+	Group       string `json:"group"`
+	MemberID    string `json:"member_id"`
+}
+
+type LeaveGroupMessageReply struct {
+	MessageType string `json:"type"`
+}
+
+func (m *LeaveGroupMessage) Reply() LeaveGroupMessageReply {
+	return LeaveGroupMessageReply{MessageType: "leave_group_ok"}
+}
+
+type RetentionPolicy struct {
+	MaxMessages int  `json:"max_messages,omitempty"`
+	MaxAgeMs    int  `json:"max_age_ms,omitempty"`
+	Compact     bool `json:"compact,omitempty"`
+}
+
+type SetRetentionMessage struct {
+	MessageType string          `json:"type"`
+	Key         string          `json:"key"`
+	Retention   RetentionPolicy `json:"retention"`
+}
+
+type SetRetentionMessageReply struct {
+	MessageType string `json:"type"`
+}
+
+func (m *SetRetentionMessage) Reply() SetRetentionMessageReply {
+	return SetRetentionMessageReply{MessageType: "set_retention_ok"}
+}
+
+type SendBatchEntry struct {
+	Key        string            `json:"key"`
+	Value      int               `json:"msg"`
+	Text       string            `json:"text,omitempty"`
+	Bytes      []byte            `json:"bytes,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Timestamp  int64             `json:"timestamp,omitempty"`
+	SubKey     string            `json:"sub_key,omitempty"`
+	ProducerID string            `json:"producer_id,omitempty"`
+	Seq        int               `json:"seq,omitempty"`
+	// Control is only decoded to reject entries forging transaction markers.
+	Control string `json:"control,omitempty"`
+}
+
+func (e SendBatchEntry) send() *SendMessage {
+	return &SendMessage{
+		MessageType: "send",
+		Key:         e.Key,
+		Value:       e.Value,
+		Text:        e.Text,
+		Bytes:       e.Bytes,
+		Headers:     e.Headers,
+		Timestamp:   e.Timestamp,
+		SubKey:      e.SubKey,
+		ProducerID:  e.ProducerID,
+		Seq:         e.Seq,
+		Control:     e.Control,
+	}
+}
+
+// SendBatchMessage carries many sends at once. Forwarded is set by the
+// handler on the batches a node handed to the leaders of their keys, which
+// don't forward them as a batch again. Clients can't set it.
+type SendBatchMessage struct {
+	MessageType string           `json:"type"`
+	Entries     []SendBatchEntry `json:"entries"`
+	Forwarded   bool             `json:"-"`
+}
+
+type SendBatchError struct {
+	Code int    `json:"code"`
+	Text string `json:"text"`
+}
+
+// SendBatchResult is the offset an entry was appended at or the error that
+// kept it from being appended.
+type SendBatchResult struct {
+	Offset int             `json:"offset"`
+	Error  *SendBatchError `json:"error,omitempty"`
+}
+
+type SendBatchMessageReply struct {
+	MessageType string            `json:"type"`
+	Results     []SendBatchResult `json:"results"`
+}
+
+func (m *SendBatchMessage) Reply(results []SendBatchResult) SendBatchMessageReply {
+	return SendBatchMessageReply{MessageType: "send_batch_ok", Results: results}
+}
+
+type BeginTxnMessage struct {
+	MessageType     string `json:"type"`
+	TransactionalID string `json:"transactional_id"`
+}
+
+type BeginTxnMessageReply struct {
+	MessageType string `json:"type"`
+	TxnEpoch    int    `json:"txn_epoch"`
+}
+
+func (m *BeginTxnMessage) Reply(epoch int) BeginTxnMessageReply {
+	return BeginTxnMessageReply{MessageType: "begin_txn_ok", TxnEpoch: epoch}
+}
+
+// AddTxnKeyMessage registers a key with the coordinator of a transaction
+// before the first record of the transaction is appended to it.
+type AddTxnKeyMessage struct {
+	MessageType     string `json:"type"`
+	TransactionalID string `json:"transactional_id"`
+	TxnEpoch        int    `json:"txn_epoch"`
+	Key             string `json:"key"`
+}
+
+type AddTxnKeyMessageReply struct {
+	MessageType string `json:"type"`
+}
+
+func (m *AddTxnKeyMessage) Reply() AddTxnKeyMessageReply {
+	return AddTxnKeyMessageReply{MessageType: "add_txn_key_ok"}
+}
+
+// EndTxnMessage is a commit_txn or an abort_txn.
+type EndTxnMessage struct {
+	MessageType     string `json:"type"`
+	TransactionalID string `json:"transactional_id"`
+	TxnEpoch        int    `json:"txn_epoch"`
+}
+
+type EndTxnMessageReply struct {
+	MessageType string `json:"type"`
+}
+
+func (m *EndTxnMessage) Reply() EndTxnMessageReply {
+	return EndTxnMessageReply{MessageType: m.MessageType + "_ok"}
+}
+
+// WriteMarkerMessage is sent by a transaction coordinator to append the
+// commit or abort marker of a transaction to a key.
+type WriteMarkerMessage struct {
+	MessageType     string `json:"type"`
+	Key             string `json:"key"`
+	TransactionalID string `json:"transactional_id"`
+	TxnEpoch        int    `json:"txn_epoch"`
+	Control         string `json:"control"`
+}
+
+type WriteMarkerMessageReply struct {
+	MessageType string `json:"type"`
+	Offset      int    `json:"offset"`
+}
+
+func (m *WriteMarkerMessage) Reply(offset int) WriteMarkerMessageReply {
+	return WriteMarkerMessageReply{MessageType: "write_marker_ok", Offset: offset}
+}
+
+type OffsetsForTimesMessage struct {
+	MessageType string           `json:"type"`
+	Timestamps  map[string]int64 `json:"timestamps"`
+	// Forwarded is set by the handler on lookups another node forwarded
+	Forwarded bool `json:"-"`
+}
+
+type OffsetsForTimesMessageReply struct {
+	MessageType string  `json:"type"`
+	Offsets     Offsets `json:"offsets"`
+}
+
+func (m *OffsetsForTimesMessage) Reply(offsets Offsets) OffsetsForTimesMessageReply {
+	return OffsetsForTimesMessageReply{MessageType: "offsets_for_times_ok", Offsets: offsets}
+}
+
+// ListKeysMessage lists the keys every node knows, Local only the ones of the
+// node receiving it.
+type ListKeysMessage struct {
+	MessageType string `json:"type"`
+	Group       string `json:"group,omitempty"`
+	Local       bool   `json:"local,omitempty"`
+}
+
+// KeyInfo describes the log of a key as the node leading it or the most
+// up to date replica sees it, Leader is only known to the leader itself.
+type KeyInfo struct {
+	LogStart      int    `json:"log_start"`
+	LogEnd        int    `json:"log_end"`
+	HighWatermark int    `json:"high_watermark"`
+	Leader        string `json:"leader,omitempty"`
+	Committed     *int   `json:"committed,omitempty"`
+}
+
+type ListKeysMessageReply struct {
+	MessageType string             `json:"type"`
+	Keys        map[string]KeyInfo `json:"keys"`
+}
+
+func (m *ListKeysMessage) Reply(keys map[string]KeyInfo) ListKeysMessageReply {
+	return ListKeysMessageReply{MessageType: "list_keys_ok", Keys: keys}
+}
+
+// DescribeKeyMessage is forwarded once to the key's leader, which knows the
+// in-sync replicas.
+type DescribeKeyMessage struct {
+	MessageType string `json:"type"`
+	Key         string `json:"key"`
+	Forwarded   bool   `json:"forwarded,omitempty"`
+}
+
+type DescribeKeyMessageReply struct {
+	MessageType   string   `json:"type"`
+	Key           string   `json:"key"`
+	Leader        string   `json:"leader"`
+	Epoch         int      `json:"epoch"`
+	Replicas      []string `json:"replicas"`
+	InSync        []string `json:"in_sync,omitempty"`
+	LogStart      int      `json:"log_start"`
+	LogEnd        int      `json:"log_end"`
+	HighWatermark int      `json:"high_watermark"`
+}
+
+type TruncateKeyMessage struct {
+	MessageType string `json:"type"`
+	Key         string `json:"key"`
+	Offset      int    `json:"offset"`
+}
+
+type TruncateKeyMessageReply struct {
+	MessageType string `json:"type"`
+	LogEnd      int    `json:"log_end"`
+}
+
+func (m *TruncateKeyMessage) Reply(logEnd int) TruncateKeyMessageReply {
+	return TruncateKeyMessageReply{MessageType: "truncate_key_ok", LogEnd: logEnd}
+}
+
+type DeleteKeyMessage struct {
+	MessageType string `json:"type"`
+	Key         string `json:"key"`
+}
+
+type DeleteKeyMessageReply struct {
+	MessageType string `json:"type"`
+	LogStart    int    `json:"log_start"`
+}
+
+func (m *DeleteKeyMessage) Reply(logStart int) DeleteKeyMessageReply {
+	return DeleteKeyMessageReply{MessageType: "delete_key_ok", LogStart: logStart}
+}
@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	POLL_RECHECK_INTERVAL time.Duration = 50 * time.Millisecond
+	// MAX_POLL_WAIT caps wait_ms, so a poll can't hold a handler forever
+	MAX_POLL_WAIT  time.Duration = 10 * time.Second
+	RECORDS_FORMAT string        = "records"
+)
+
+// pollLimits caps the records handed out by one poll across all its keys. A
+// zero limit is unlimited, and the first record is always handed out so a
+// record larger than max_bytes doesn't stall the consumer.
+type pollLimits struct {
+	maxMessages int
+	maxBytes    int
+	format      string
+	messages    int
+	bytes       int
+}
+
+func newPollLimits(maxMessages int, maxBytes int, format string) *pollLimits {
+	return &pollLimits{maxMessages: maxMessages, maxBytes: maxBytes, format: format}
+}
+
+// take returns how many of records fit in the remaining limits, sized by
+// the messages or records of format they are handed out as.
+func (l *pollLimits) take(records []Record) int {
+	for i, record := range records {
+		if l.maxMessages > 0 && l.messages >= l.maxMessages {
+			return i
+		}
+
+		size := 0
+		if l.maxBytes > 0 {
+			var handedOut any = record.Message()
+			if l.format == RECORDS_FORMAT {
+				handedOut = record.Polled()
+			}
+			encoded, _ := json.Marshal(handedOut)
+			size = len(encoded)
+			if l.messages > 0 && l.bytes+size > l.maxBytes {
+				return i
+			}
+		}
+
+		l.messages += 1
+		l.bytes += size
+	}
+
+	return len(records)
+}
+
+// exhausted reports whether no more records fit in the limits.
+func (l *pollLimits) exhausted() bool {
+	return l.maxMessages > 0 && l.messages >= l.maxMessages || l.maxBytes > 0 && l.messages > 0 && l.bytes >= l.maxBytes
+}
+
+// remaining returns the limits left for a poll forwarded to another node, a
+// zero limit is still unlimited.
+func (l *pollLimits) remaining() (int, int) {
+	maxMessages, maxBytes := l.maxMessages, l.maxBytes
+	if maxMessages > 0 {
+		maxMessages -= l.messages
+	}
+	if maxBytes > 0 {
+		maxBytes = max(maxBytes-l.bytes, 1)
+	}
+	return maxMessages, maxBytes
+}
+
+// count counts the messages or records another node handed out against the
+// limits.
+func (l *pollLimits) count(messages []Message, records []PolledRecord) {
+	handedOut := make([]any, 0, len(messages)+len(records))
+	for _, message := range messages {
+		handedOut = append(handedOut, message)
+	}
+	for _, record := range records {
+		handedOut = append(handedOut, record)
+	}
+
+	for _, item := range handedOut {
+		l.messages += 1
+		if l.maxBytes > 0 {
+			encoded, _ := json.Marshal(item)
+			l.bytes += len(encoded)
+		}
+	}
+}
+
+// appendedSignal returns a channel closed the next time records become
+// visible to polls on this node.
+func (s *KafkaSever) appendedSignal() <-chan struct{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.appended
+}
+
+func (s *KafkaSever) notifyAppended() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	close(s.appended)
+	s.appended = make(chan struct{})
+}
+
+// Wait returns how long the poll may block for records, wait_ms capped at
+// MAX_POLL_WAIT.
+func (m *PollMessage) Wait() time.Duration {
+	return min(time.Duration(max(m.WaitMs, 0))*time.Millisecond, MAX_POLL_WAIT)
+}
+
+// waitForRecords returns once appended is closed, on the deadline or after
+// POLL_RECHECK_INTERVAL, as keys read from lin-kv aren't signalled. It
+// returns false once ctx is done.
+func waitForRecords(ctx context.Context, appended <-chan struct{}, deadline time.Time) bool {
+	timer := time.NewTimer(min(time.Until(deadline), POLL_RECHECK_INTERVAL))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-appended:
+	case <-timer.C:
+	}
+	return true
+}
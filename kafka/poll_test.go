@@ -0,0 +1,147 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestPollLimits(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	loadPartition(kafkaServer, "luck", []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)})
+	loadPartition(kafkaServer, "prize", []Message{NewMessage(0, 1), NewMessage(1, 4)})
+	ctx := context.TODO()
+
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0, "prize": 0}, MaxMessages: 4}, ctx)
+
+	if !slices.Equal(reply.Messages["luck"], []Message{NewMessage(0, 11), NewMessage(1, 12), NewMessage(2, 45)}) || !slices.Equal(reply.Messages["prize"], []Message{NewMessage(0, 1)}) {
+		t.Errorf("expected 4 messages in key order but polled %v", reply.Messages)
+	}
+
+	if !maps.Equal(reply.NextOffsets, Offsets{"luck": 3, "prize": 1}) {
+		t.Errorf("expected next offsets luck:3 prize:1 but were %v", reply.NextOffsets)
+	}
+
+	// every record encodes to at least 5 bytes
+	reply, _ = kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 1}, MaxBytes: 3}, ctx)
+	if !slices.Equal(reply.Messages["luck"], []Message{NewMessage(1, 12)}) {
+		t.Errorf("expected only the first record when it exceeds max_bytes but polled %v", reply.Messages["luck"])
+	}
+
+	reply, _ = kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 3}}, ctx)
+	if len(reply.Messages["luck"]) != 0 || reply.NextOffsets["luck"] != 3 {
+		t.Errorf("expected nothing past the end of the log but got %v next at %v", reply.Messages, reply.NextOffsets)
+	}
+}
+
+func TestLongPoll(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 11}, ctx)
+	}()
+
+	start := time.Now()
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}, WaitMs: 5000}, ctx)
+
+	if !slices.Equal(reply.Messages["luck"], []Message{NewMessage(0, 11)}) {
+		t.Errorf("expected long poll to return the sent record but polled %v", reply.Messages)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("long poll waited %v after the record was sent", time.Since(start))
+	}
+}
+
+func TestLongPollTimesOut(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	loadPartition(kafkaServer, "luck", []Message{NewMessage(0, 11)})
+
+	start := time.Now()
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 1}, WaitMs: 100}, context.TODO())
+
+	if reply.hasMessages() {
+		t.Errorf("expected no records but polled %v", reply.Messages)
+	}
+
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Errorf("long poll returned after %v before wait_ms", waited)
+	}
+}
+
+func TestPollRecordsFormat(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 11}, ctx)
+	kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Text: "clover", Bytes: []byte{0, 7}, Headers: map[string]string{"source": "field"}, Timestamp: 1700000000000}, ctx)
+
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}, Format: RECORDS_FORMAT}, ctx)
+	if len(reply.Messages["luck"]) != 0 {
+		t.Errorf("expected no messages in the records format but got %v", reply.Messages)
+	}
+
+	records := reply.Records["luck"]
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records but got %v", records)
+	}
+
+	if records[0].Value != 11 || records[0].Timestamp == 0 {
+		t.Errorf("expected the integer value with its append timestamp but got %+v", records[0])
+	}
+
+	polled := records[1]
+	if polled.Offset != 1 || polled.Text != "clover" || !bytes.Equal(polled.Bytes, []byte{0, 7}) || polled.Headers["source"] != "field" {
+		t.Errorf("expected the text, bytes and headers sent but got %+v", polled)
+	}
+
+	if polled.ProducerTimestamp != 1700000000000 || polled.Timestamp < records[0].Timestamp {
+		t.Errorf("expected the producer timestamp and an append timestamp not before the previous record but got %+v", polled)
+	}
+}
+
+func TestPollWaitIsCapped(t *testing.T) {
+	if wait := (&PollMessage{WaitMs: 5000}).Wait(); wait != 5*time.Second {
+		t.Errorf("expected a wait of 5s but got %v", wait)
+	}
+	if wait := (&PollMessage{WaitMs: 3_600_000}).Wait(); wait != MAX_POLL_WAIT {
+		t.Errorf("expected the wait to be capped at %v but got %v", MAX_POLL_WAIT, wait)
+	}
+	if wait := (&PollMessage{WaitMs: -1}).Wait(); wait != 0 {
+		t.Errorf("expected a negative wait_ms not to wait but got %v", wait)
+	}
+}
+
+func TestPollForwardsKeysHeldElsewhere(t *testing.T) {
+	node := maelstrom.NewNode()
+	node.Init("n0", []string{"n0", "n1", "n2", "n3"})
+	kafkaServer := NewKafkaSeverWithStores(node, kvstore.NewMemoryStore(), kvstore.NewMemoryStore(), kvstore.NewBarrierFreshness(node))
+	kafkaServer.SetReplicationFactor(1)
+	key := "luck"
+	for i := 0; kafkaServer.replicas(key)[0] == "n0"; i++ {
+		key = fmt.Sprintf("luck-%d", i)
+	}
+
+	// the replica holding key doesn't answer
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{key: 0}}, ctx); err == nil {
+		t.Errorf("expected the poll of %s to fail rather than leave it out", key)
+	}
+	if _, err := kafkaServer.OffsetsForTimes(&OffsetsForTimesMessage{MessageType: "offsets_for_times", Timestamps: map[string]int64{key: 0}}, ctx); err == nil {
+		t.Errorf("expected the lookup of %s to fail rather than leave it out", key)
+	}
+
+	if !kafkaServer.FromNode("n1") || kafkaServer.FromNode("c1") {
+		t.Error("expected only nodes of the cluster to forward requests")
+	}
+}
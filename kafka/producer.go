@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"fmt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// PRODUCER_WINDOW is how many of a producer's latest sends are remembered to
+// answer retries with their original offset.
+const PRODUCER_WINDOW int = 5
+
+type producedRecord struct {
+	seq    int
+	offset int
+}
+
+// producerState is what a partition knows of one producer, its last
+// sequence number and the offsets of its latest sends.
+type producerState struct {
+	lastSeq int
+	recent  []producedRecord
+}
+
+// observeProducers records the sends of idempotent producers and the markers
+// of ended transactions among records, which were just appended to p. The
+// caller holds p.lock.
+func (p *partition) observeProducers(records []Record) {
+	for _, record := range records {
+		if record.Control != "" {
+			p.endedTxns[record.TransactionalID] = max(p.endedTxns[record.TransactionalID], record.TxnEpoch)
+			continue
+		}
+
+		if record.ProducerID == "" {
+			continue
+		}
+
+		state, ok := p.producers[record.ProducerID]
+		if !ok {
+			state = &producerState{}
+			p.producers[record.ProducerID] = state
+		}
+
+		state.lastSeq = record.Seq
+		state.recent = append(state.recent, producedRecord{seq: record.Seq, offset: record.Offset})
+		if len(state.recent) > PRODUCER_WINDOW {
+			state.recent = state.recent[1:]
+		}
+	}
+}
+
+// rebuildProducers recomputes the producer states from the records in the
+// log, after records were truncated or reloaded. Producers whose records
+// were all removed by retention or compaction are forgotten and start over.
+// The caller holds p.lock.
+func (p *partition) rebuildProducers() {
+	clear(p.producers)
+	clear(p.endedTxns)
+	p.observeProducers(p.log.records)
+}
+
+// checkSequence returns the offset of an earlier send of producer with seq,
+// if it was already appended. A producer the partition doesn't know can
+// start at any sequence number, others have to send the next one. The
+// caller holds p.lock.
+func (p *partition) checkSequence(producer string, seq int) (int, bool, error) {
+	state, ok := p.producers[producer]
+	if !ok {
+		return 0, false, nil
+	}
+
+	for _, produced := range state.recent {
+		if produced.seq == seq {
+			return produced.offset, true, nil
+		}
+	}
+
+	if seq <= state.lastSeq {
+		return 0, false, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("sequence %d of %s is older than the last %d sends", seq, producer, PRODUCER_WINDOW))
+	}
+
+	if seq != state.lastSeq+1 {
+		return 0, false, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("sequence %d of %s out of order, expected %d", seq, producer, state.lastSeq+1))
+	}
+
+	return 0, false, nil
+}
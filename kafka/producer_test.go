@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestIdempotentSend(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+
+	for _, seq := range []int{0, 1, 1, 0} {
+		kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 10 + seq, ProducerID: "p1", Seq: seq}, ctx)
+	}
+
+	reply, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 11, ProducerID: "p1", Seq: 1}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Offset != 1 {
+		t.Errorf("expected the retried send to get its original offset 1 but got %d", reply.Offset)
+	}
+
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); len(messages) != 2 {
+		t.Errorf("expected retries to not be appended but log was %v", messages)
+	}
+
+	// sends without a producer id are never deduplicated
+	kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 11}, ctx)
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); len(messages) != 3 {
+		t.Errorf("expected send without producer id to be appended but log was %v", messages)
+	}
+}
+
+func TestIdempotentSendOutOfOrder(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	if _, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 10, ProducerID: "p1", Seq: 4}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 12, ProducerID: "p1", Seq: 6}, ctx)
+	if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected a skipped sequence number to be rejected but got %v", err)
+	}
+}
+
+func TestIdempotentSendAfterReload(t *testing.T) {
+	first := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	if _, err := first.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 10, ProducerID: "p1", Seq: 0}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted leader only finds the send in lin-kv
+	restarted := newTestKafkaServer(kvstore.NewMemoryStore())
+	restarted.linKV, restarted.segments = first.linKV, first.segments
+	reply, err := restarted.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 10, ProducerID: "p1", Seq: 0}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Offset != 0 {
+		t.Errorf("expected the retried send to get its original offset 0 but got %d", reply.Offset)
+	}
+
+	if messages, _ := replicatedMessages(restarted, "luck"); len(messages) != 1 {
+		t.Errorf("expected the retry to not be appended but log was %v", messages)
+	}
+}
+
+func TestFollowerTracksProducers(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	produced := []Record{{Offset: 0, Value: 10, ProducerID: "p1", Seq: 0}, {Offset: 1, Value: 11, ProducerID: "p1", Seq: 1}}
+	if _, err := kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", Records: produced, End: 2, HighWatermark: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := kafkaServer.partition("luck", false)
+	if offset, duplicate, err := p.checkSequence("p1", 1); err != nil || !duplicate || offset != 1 {
+		t.Errorf("expected follower to know seq 1 of p1 at offset 1 but got %d %v %v", offset, duplicate, err)
+	}
+}
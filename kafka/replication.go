@@ -0,0 +1,336 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// partition is the local copy of one key's log. On the leader, matched holds
+// the log end each follower acknowledged and inSync the followers every send
+// waits for. highWatermark is the end of the prefix stored in lin-kv and on
+// every in-sync replica, nothing past it is handed out by polls. epoch is the
+// newest leader epoch seen and storedEpoch the one last read from or written
+// to the tail segment in lin-kv. compactedTo is the offset the log was last
+// compacted up to.
+type partition struct {
+	lock          *sync.Mutex
+	log           recordLog
+	highWatermark int
+	compactedTo   int
+	leader        bool
+	epoch         int
+	storedEpoch   int
+	matched       map[string]int
+	inSync        map[string]struct{}
+	producers     map[string]*producerState
+	endedTxns     map[string]int
+}
+
+func newPartition() *partition {
+	return &partition{
+		lock:      &sync.Mutex{},
+		log:       newRecordLog(),
+		matched:   make(map[string]int),
+		inSync:    make(map[string]struct{}),
+		producers: make(map[string]*producerState),
+		endedTxns: make(map[string]int),
+	}
+}
+
+// lead makes the partition track followers for epoch, they start out in sync
+// so the first send brings them up to date.
+func (p *partition) lead(epoch int, followers []string) {
+	if p.leader && p.epoch == epoch {
+		return
+	}
+
+	p.leader = true
+	p.epoch = epoch
+	clear(p.matched)
+	clear(p.inSync)
+	for _, follower := range followers {
+		p.matched[follower] = 0
+		p.inSync[follower] = struct{}{}
+	}
+}
+
+// replicas returns the nodes holding key, the first one is its preferred
+// leader.
+func (s *KafkaSever) replicas(key string) []string {
+	if len(s.node.NodeIDs()) == 0 {
+		return []string{s.node.ID()}
+	}
+
+	// the node ids are only known after init
+	s.ringOnce.Do(func() {
+		s.ring = newRing(s.node.NodeIDs(), VIRTUAL_NODES)
+	})
+	return s.ring.owners(key, s.replicationFactor)
+}
+
+// followers returns the replicas of key other than this node.
+func (s *KafkaSever) followers(key string) []string {
+	return slices.DeleteFunc(s.replicas(key), func(replica string) bool { return replica == s.node.ID() })
+}
+
+// replicatedLog returns the log of key up to the high-water mark if this node
+// holds it.
+func (s *KafkaSever) replicatedLog(key string) (recordLog, bool) {
+	p, ok := s.partition(key, false)
+	if !ok {
+		return recordLog{}, false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.log.end == 0 {
+		return recordLog{}, false
+	}
+	return p.log.upTo(p.highWatermark), true
+}
+
+// replicate ships the records each in-sync follower is missing and advances
+// the high-water mark once they all acknowledged. Followers that fail are
+// dropped from the in-sync set and caught up by ReplicaSyncer. Below
+// minInSync in-sync replicas the high-water mark stays put and the append
+// isn't acknowledged, as losing the leader would lose it. A follower that saw
+// a newer epoch makes the leader step down. The caller holds p.lock.
+func (s *KafkaSever) replicate(ctx context.Context, key string, p *partition) error {
+	followers := make([]string, 0, len(p.inSync))
+	for follower := range p.inSync {
+		followers = append(followers, follower)
+	}
+
+	logEnds, fenced := s.sendReplicas(ctx, key, p, followers)
+	if fenced {
+		return s.stepDown(key, p)
+	}
+	for _, follower := range followers {
+		logEnd, ok := logEnds[follower]
+		if !ok || logEnd != p.log.end {
+			log.Printf("%s fell out of sync for %s", follower, key)
+			delete(p.inSync, follower)
+			continue
+		}
+		p.matched[follower] = logEnd
+	}
+
+	if !s.advanceHighWatermark(key, p) {
+		return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("%s has %d in-sync replicas, fewer than %d", key, len(p.inSync)+1, s.minInSync(key)))
+	}
+	return nil
+}
+
+// minInSync returns the number of in-sync replicas, the leader included,
+// appends to key wait for. Keys with fewer replicas wait for all of them.
+func (s *KafkaSever) minInSync(key string) int {
+	return min(s.minInSyncReplicas, len(s.replicas(key)))
+}
+
+// advanceHighWatermark moves the high-water mark to the log end if enough
+// replicas are in sync and all of them hold the whole log, and reports
+// whether it did. The caller holds p.lock.
+func (s *KafkaSever) advanceHighWatermark(key string, p *partition) bool {
+	if len(p.inSync)+1 < s.minInSync(key) {
+		return false
+	}
+	for follower := range p.inSync {
+		if p.matched[follower] != p.log.end {
+			return false
+		}
+	}
+
+	if p.highWatermark != p.log.end {
+		p.highWatermark = p.log.end
+		s.notifyAppended()
+	}
+	return true
+}
+
+// stepDown gives up leading key after a follower rejected the leader's epoch
+// as fenced by a newer one. The caller holds p.lock.
+func (s *KafkaSever) stepDown(key string, p *partition) error {
+	log.Printf("stepping down as leader of %s at epoch %d, a follower saw a newer epoch", key, p.epoch)
+	p.leader = false
+	s.dropLease(key, p.epoch)
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("leader of %s at epoch %d fenced by a newer epoch", key, p.epoch))
+}
+
+// replicateMessage returns the records follower is missing. The high-water
+// mark is the leader's, records past it may still be cut off by a new leader
+// so followers hand them out only once a later message moved it past them.
+// The caller holds p.lock.
+func (p *partition) replicateMessage(key string, follower string) ReplicateMessage {
+	from := min(max(p.matched[follower], p.log.start), p.log.end)
+	return ReplicateMessage{
+		MessageType:   "replicate",
+		Key:           key,
+		Epoch:         p.epoch,
+		From:          from,
+		Records:       p.log.from(from),
+		End:           p.log.end,
+		HighWatermark: p.highWatermark,
+		LogStart:      p.log.start,
+		CompactedTo:   p.compactedTo,
+	}
+}
+
+// sendReplicas returns the log ends followers acknowledged and whether any of
+// them rejected the leader's epoch.
+func (s *KafkaSever) sendReplicas(ctx context.Context, key string, p *partition, followers []string) (map[string]int, bool) {
+	logEnds := make(map[string]int)
+	fenced := false
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, follower := range followers {
+		replicateMessage := p.replicateMessage(key, follower)
+
+		wg.Add(1)
+		go func(follower string) {
+			defer wg.Done()
+			reply, err := s.node.SyncRPC(ctx, follower, replicateMessage)
+			if err != nil {
+				log.Printf("failed to replicate %s to %s: %v", key, follower, err)
+				if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+					lock.Lock()
+					fenced = true
+					lock.Unlock()
+				}
+				return
+			}
+
+			replicateReply := new(ReplicateMessageReply)
+			if err := json.Unmarshal(reply.Body, replicateReply); err != nil {
+				log.Printf("failed to unmarshal replicate reply: %v", err)
+				return
+			}
+
+			lock.Lock()
+			logEnds[follower] = replicateReply.LogEnd
+			lock.Unlock()
+		}(follower)
+	}
+	wg.Wait()
+
+	return logEnds, fenced
+}
+
+// Replicate appends records shipped by the leader. Records the follower
+// already has are overwritten, so a diverged tail is replaced by the
+// leader's, and a gap is reported through the log end so the leader resends.
+// A follower missing records the leader's retention removed starts over at
+// the leader's log start. Retention and compaction are applied as on the
+// leader. Leaders of an older epoch than one already seen are rejected.
+func (s *KafkaSever) Replicate(msg *ReplicateMessage) (ReplicateMessageReply, error) {
+	p, _ := s.partition(msg.Key, true)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if msg.Epoch < p.epoch {
+		return ReplicateMessageReply{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("epoch %d of %s fenced by epoch %d", msg.Epoch, msg.Key, p.epoch))
+	}
+
+	if msg.Epoch > p.epoch {
+		p.leader = false
+		p.epoch = msg.Epoch
+	}
+
+	if msg.From > p.log.end && msg.From > msg.LogStart {
+		log.Printf("gap replicating %s: have records up to %d but got records from %d", msg.Key, p.log.end, msg.From)
+		return msg.Reply(p.log.end), nil
+	}
+
+	from := msg.From
+	if msg.From > p.log.end {
+		p.log.reset(msg.From)
+	}
+
+	truncated := msg.From < p.log.end
+	p.log.truncate(msg.From)
+	p.log.append(msg.Records, msg.End)
+	if truncated {
+		p.rebuildProducers()
+	} else {
+		p.observeProducers(msg.Records)
+	}
+	p.highWatermark = min(p.highWatermark, p.log.end)
+	p.log.trimHead(msg.LogStart)
+	if msg.CompactedTo > p.compactedTo {
+		p.log.compact(msg.CompactedTo)
+		p.compactedTo = msg.CompactedTo
+	}
+
+	if err := s.segments.Mirror(context.Background(), msg.Key, p.log, from, msg.Epoch); err != nil {
+		log.Printf("failed to store replicated records of %s: %v", msg.Key, err)
+		return ReplicateMessageReply{}, kvstore.Unavailable(err)
+	}
+
+	if highWatermark := min(msg.HighWatermark, p.log.end); highWatermark > p.highWatermark {
+		p.highWatermark = highWatermark
+		s.notifyAppended()
+	}
+	return msg.Reply(p.log.end), nil
+}
+
+// ReplicaSyncer brings followers that fell out of sync back up to date and
+// re-admits them, and keeps in-sync followers' high-water marks current.
+func (s *KafkaSever) ReplicaSyncer(ctx context.Context) {
+	ticker := time.NewTicker(REPLICA_SYNC_FREQUENCY)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.lock.RLock()
+			partitions := make(map[string]*partition, len(s.partitions))
+			for key, p := range s.partitions {
+				partitions[key] = p
+			}
+			s.lock.RUnlock()
+
+			for key, p := range partitions {
+				s.syncReplicas(ctx, key, p)
+			}
+		}
+	}
+}
+
+func (s *KafkaSever) syncReplicas(ctx context.Context, key string, p *partition) {
+	ctx, cancel := context.WithTimeout(ctx, REPLICA_SYNC_FREQUENCY)
+	defer cancel()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !s.leads(key, p) {
+		return
+	}
+
+	followers := make([]string, 0, len(p.matched))
+	for follower := range p.matched {
+		followers = append(followers, follower)
+	}
+
+	logEnds, fenced := s.sendReplicas(ctx, key, p, followers)
+	if fenced {
+		s.stepDown(key, p)
+		return
+	}
+	for follower, logEnd := range logEnds {
+		p.matched[follower] = logEnd
+		if _, ok := p.inSync[follower]; !ok && logEnd == p.log.end {
+			log.Printf("%s back in sync for %s", follower, key)
+			p.inSync[follower] = struct{}{}
+		}
+	}
+	// records of appends that failed to replicate but couldn't be cut off
+	s.advanceHighWatermark(key, p)
+}
@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestReplicas(t *testing.T) {
+	node := maelstrom.NewNode()
+	node.Init("n0", []string{"n0", "n1", "n2", "n3"})
+	kafkaServer := NewKafkaSeverWithStores(node, kvstore.NewMemoryStore(), kvstore.NewMemoryStore(), kvstore.NewBarrierFreshness(node))
+	kafkaServer.SetReplicationFactor(3)
+
+	replicas := kafkaServer.replicas("luck")
+	if len(replicas) != 3 || len(slices.Compact(slices.Sorted(slices.Values(replicas)))) != 3 {
+		t.Errorf("expected 3 distinct replicas but were %v", replicas)
+	}
+
+	if !slices.Equal(kafkaServer.replicas("luck"), replicas) {
+		t.Errorf("replicas of a key should be stable but were %v then %v", replicas, kafkaServer.replicas("luck"))
+	}
+
+	kafkaServer.SetReplicationFactor(10)
+	if replicas := kafkaServer.replicas("luck"); len(replicas) != 4 {
+		t.Errorf("expected replicas capped at cluster size but were %v", replicas)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+
+	reply, err := kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", From: 0, Records: records(NewMessage(0, 11), NewMessage(1, 12)), End: 2, HighWatermark: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.MessageType != "replicate_ok" || reply.LogEnd != 2 {
+		t.Errorf("expected replicate_ok with log end 2 but got %v", reply)
+	}
+
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); !slices.Equal(messages, []Message{NewMessage(0, 11)}) {
+		t.Errorf("expected polls capped at the high-water mark but got %v", messages)
+	}
+
+	// a diverged tail is replaced by the leader's records
+	reply, err = kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", From: 1, Records: records(NewMessage(1, 13), NewMessage(2, 14)), End: 3, HighWatermark: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.LogEnd != 3 {
+		t.Errorf("expected log end 3 but was %d", reply.LogEnd)
+	}
+
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); !slices.Equal(messages, []Message{NewMessage(0, 11), NewMessage(1, 13), NewMessage(2, 14)}) {
+		t.Errorf("expected diverged tail to be replaced but got %v", messages)
+	}
+}
+
+func TestReplicateGap(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+
+	reply, err := kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", From: 2, Records: records(NewMessage(2, 11)), End: 3, HighWatermark: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.LogEnd != 0 {
+		t.Errorf("expected log end 0 so the leader resends but was %d", reply.LogEnd)
+	}
+
+	if _, ok := replicatedMessages(kafkaServer, "luck"); ok {
+		t.Error("records after a gap should not be stored")
+	}
+}
+
+func TestSendReloadsLogBehindLinKV(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	kafkaServer.linKV.Write(ctx, segmentKey("luck", 0), logSegment{Epoch: 1, Records: records(NewMessage(0, 11))})
+
+	reply, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 12}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Offset != 1 {
+		t.Errorf("expected offset 1 after reloading the log but was %d", reply.Offset)
+	}
+}
+
+func TestReplicateWaitsForMinInSyncReplicas(t *testing.T) {
+	node := maelstrom.NewNode()
+	node.Init("n0", []string{"n0", "n1", "n2"})
+	kafkaServer := NewKafkaSeverWithStores(node, kvstore.NewMemoryStore(), kvstore.NewMemoryStore(), kvstore.NewBarrierFreshness(node))
+	ctx := context.TODO()
+
+	// both followers fell out of sync
+	p, _ := kafkaServer.partition("luck", true)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lead(1, nil)
+	p.log.append(records(NewMessage(0, 11)), 1)
+
+	if err := kafkaServer.replicate(ctx, "luck", p); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected an append with only the leader in sync to be unavailable but got %v", err)
+	}
+	if p.highWatermark != 0 {
+		t.Errorf("expected the high-water mark to stay at 0 but was %d", p.highWatermark)
+	}
+
+	// a follower caught up
+	p.inSync["n1"], p.matched["n1"] = struct{}{}, 1
+	if !kafkaServer.advanceHighWatermark("luck", p) || p.highWatermark != 1 {
+		t.Errorf("expected the high-water mark to move to 1 with 2 replicas in sync but was %d", p.highWatermark)
+	}
+
+	kafkaServer.SetMinInSyncReplicas(1)
+	delete(p.inSync, "n1")
+	if err := kafkaServer.replicate(ctx, "luck", p); err != nil {
+		t.Errorf("expected a single in-sync replica to be enough but got %v", err)
+	}
+}
+
+func TestStepDownWhenFollowerSawNewerEpoch(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	p, _ := kafkaServer.partition("luck", true)
+	p.lead(1, nil)
+
+	if err := kafkaServer.stepDown("luck", p); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable || p.leader {
+		t.Errorf("expected the leader to step down as unavailable but got %v, still leading: %t", err, p.leader)
+	}
+}
+
+func TestSendDropsUnreplicatedRecords(t *testing.T) {
+	node := maelstrom.NewNode()
+	node.Init("n0", []string{"n0", "n1", "n2"})
+	kafkaServer := NewKafkaSeverWithStores(node, kvstore.NewMemoryStore(), kvstore.NewMemoryStore(), kvstore.NewBarrierFreshness(node))
+
+	// the followers don't answer
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 11}, ctx); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected the send to be unavailable but got %v", err)
+	}
+
+	p, _ := kafkaServer.partition("luck", false)
+	if p.log.end != 0 {
+		t.Errorf("expected the unreplicated record to be cut from the cache but log ends at %d", p.log.end)
+	}
+	if stored, _, err := kafkaServer.segments.Read(context.TODO(), "luck", 0); err != nil || stored.end != 0 {
+		t.Errorf("expected the unreplicated record to be cut from the storage but log ends at %d: %v", stored.end, err)
+	}
+}
+
+func TestFollowersOnlyLearnTheHighWatermark(t *testing.T) {
+	p := newPartition()
+	p.lead(1, []string{"n1"})
+	p.log.append(records(NewMessage(0, 11), NewMessage(1, 12)), 2)
+	p.highWatermark = 1
+
+	if message := p.replicateMessage("luck", "n1"); message.End != 2 || message.HighWatermark != 1 {
+		t.Errorf("expected records up to 2 with high-water mark 1 but got %d and %d", message.End, message.HighWatermark)
+	}
+}
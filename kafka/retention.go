@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"maps"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	RETENTION_KEY_PREFIX string        = "retention-"
+	CLEANER_FREQUENCY    time.Duration = 1 * time.Second
+)
+
+func retentionKey(key string) string {
+	return RETENTION_KEY_PREFIX + key
+}
+
+// SetRetention stores the retention policy of a key in lin-kv, where the
+// key's leader picks it up on its next cleaner run.
+func (s *KafkaSever) SetRetention(msg *SetRetentionMessage, ctx context.Context) (SetRetentionMessageReply, error) {
+	if err := s.linKV.Write(ctx, retentionKey(msg.Key), msg.Retention); err != nil {
+		log.Printf("failed to store retention of %s: %v", msg.Key, err)
+		return SetRetentionMessageReply{}, kvstore.Unavailable(err)
+	}
+
+	return msg.Reply(), nil
+}
+
+// LogCleaner applies the retention policies of the keys this node leads.
+func (s *KafkaSever) LogCleaner(ctx context.Context) {
+	ticker := time.NewTicker(CLEANER_FREQUENCY)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.lock.RLock()
+			partitions := maps.Clone(s.partitions)
+			s.lock.RUnlock()
+
+			for key, p := range partitions {
+				s.clean(ctx, key, p)
+			}
+		}
+	}
+}
+
+// clean removes the segments before the log start the policy of key calls
+// for and compacts the closed segments, first in lin-kv and then in memory.
+// Followers follow the leader's log start and compaction offset through
+// replication.
+func (s *KafkaSever) clean(ctx context.Context, key string, p *partition) {
+	ctx, cancel := context.WithTimeout(ctx, CLEANER_FREQUENCY)
+	defer cancel()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !s.leads(key, p) {
+		return
+	}
+
+	policy := RetentionPolicy{}
+	if err := s.linKV.ReadInto(ctx, retentionKey(key), &policy); err != nil {
+		if maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			log.Printf("failed to read retention of %s: %v", key, err)
+		}
+		return
+	}
+
+	start := s.logStart(p, policy)
+	if start > p.log.start {
+		if err := s.segments.TrimHead(ctx, key, p.log, start, p.storedEpoch); err != nil {
+			log.Printf("failed to move log start of %s to %d: %v", key, start, err)
+			return
+		}
+
+		log.Printf("retention moved log start of %s from %d to %d", key, p.log.start, start)
+		p.log.trimHead(start)
+	}
+
+	compactTo := s.segments.segmentStart(p.highWatermark)
+	if !policy.Compact || compactTo <= p.compactedTo {
+		return
+	}
+
+	// the stored segments are compacted first, so a failure leaves the log
+	// as it is stored and the next run tries again
+	compacted := p.log
+	if compacted.compact(compactTo) {
+		if err := s.segments.Compact(ctx, key, p.log, compacted, compactTo); err != nil {
+			log.Printf("failed to store compacted log of %s: %v", key, err)
+			return
+		}
+	}
+	p.log = compacted
+	p.compactedTo = compactTo
+}
+
+// logStart returns the log start policy calls for. Like Kafka, retention
+// removes whole segments, so up to a segment more than the policy asks for
+// is kept, and nothing past the high-water mark is removed.
+func (s *KafkaSever) logStart(p *partition, policy RetentionPolicy) int {
+	start := p.log.start
+	if policy.MaxMessages > 0 {
+		start = max(start, p.log.end-policy.MaxMessages)
+	}
+
+	if policy.MaxAgeMs > 0 {
+		cutoff := time.Now().UnixMilli() - int64(policy.MaxAgeMs)
+		expired := p.log.end
+		for _, record := range p.log.records {
+			if record.Timestamp >= cutoff {
+				expired = record.Offset
+				break
+			}
+		}
+		start = max(start, expired)
+	}
+
+	return max(p.log.start, s.segments.segmentStart(min(start, p.highWatermark)))
+}
@@ -0,0 +1,160 @@
+package kafka
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newTestRetentionServer(t *testing.T, values []int, subKeys []string) *KafkaSever {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	kafkaServer.segments = newSegmentStore(kafkaServer.linKV, 2)
+	for i, value := range values {
+		msg := SendMessage{MessageType: "send", Key: "luck", Value: value}
+		if subKeys != nil {
+			msg.SubKey = subKeys[i]
+		}
+		if _, err := kafkaServer.Send(&msg, context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return kafkaServer
+}
+
+func clean(t *testing.T, kafkaServer *KafkaSever, policy RetentionPolicy) {
+	ctx := context.TODO()
+	if _, err := kafkaServer.SetRetention(&SetRetentionMessage{MessageType: "set_retention", Key: "luck", Retention: policy}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := kafkaServer.partition("luck", false)
+	kafkaServer.clean(ctx, "luck", p)
+}
+
+func TestRetentionMaxMessages(t *testing.T) {
+	kafkaServer := newTestRetentionServer(t, []int{10, 11, 12, 13, 14, 15, 16}, nil)
+	ctx := context.TODO()
+
+	clean(t, kafkaServer, RetentionPolicy{MaxMessages: 3})
+
+	// the last 3 records start in the segment from offset 4
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 1}}, ctx)
+	if !slices.Equal(reply.Messages["luck"], []Message{NewMessage(4, 14), NewMessage(5, 15), NewMessage(6, 16)}) {
+		t.Errorf("expected records from offset 4 but polled %v", reply.Messages["luck"])
+	}
+
+	if !maps.Equal(reply.LogStartOffsets, Offsets{"luck": 4}) || reply.NextOffsets["luck"] != 7 {
+		t.Errorf("expected log start 4 and next offset 7 but got %v and %v", reply.LogStartOffsets, reply.NextOffsets)
+	}
+
+	stored, _, err := kafkaServer.segments.Read(ctx, "luck", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.start != 4 || !slices.Equal(toMessages(stored.records), reply.Messages["luck"]) {
+		t.Errorf("expected lin-kv log to start at 4 but was %v from %d", stored.records, stored.start)
+	}
+
+	expired := logSegment{}
+	kafkaServer.linKV.ReadInto(ctx, segmentKey("luck", 0), &expired)
+	if len(expired.Records) != 0 {
+		t.Errorf("expected expired segment to be emptied but was %v", expired)
+	}
+
+	// offsets keep counting from the end of the log
+	if sent, _ := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 17}, ctx); sent.Offset != 7 {
+		t.Errorf("expected offset 7 after retention but was %d", sent.Offset)
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	kafkaServer := newTestRetentionServer(t, []int{10, 11, 12, 13, 14}, nil)
+	p, _ := kafkaServer.partition("luck", false)
+	for i := range 3 {
+		p.log.records[i].Timestamp = 0
+	}
+
+	clean(t, kafkaServer, RetentionPolicy{MaxAgeMs: 60_000})
+
+	if p.log.start != 2 || !slices.Equal(toMessages(p.log.records), []Message{NewMessage(2, 12), NewMessage(3, 13), NewMessage(4, 14)}) {
+		t.Errorf("expected the segment holding the first unexpired record to be kept but log was %v from %d", p.log.records, p.log.start)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	kafkaServer := newTestRetentionServer(t, []int{1, 2, 3, 4, 5, 6}, []string{"a", "b", "a", "a", "b", "c"})
+	ctx := context.TODO()
+
+	clean(t, kafkaServer, RetentionPolicy{Compact: true})
+
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}}, ctx)
+	if !slices.Equal(reply.Messages["luck"], []Message{NewMessage(3, 4), NewMessage(4, 5), NewMessage(5, 6)}) {
+		t.Errorf("expected only the latest record of every sub key but polled %v", reply.Messages["luck"])
+	}
+
+	reader := newTestKafkaServer(kvstore.NewMemoryStore())
+	reader.linKV, reader.segments = kafkaServer.linKV, kafkaServer.segments
+	stored, _ := reader.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}}, ctx)
+	if !slices.Equal(stored.Messages["luck"], reply.Messages["luck"]) || stored.NextOffsets["luck"] != 6 {
+		t.Errorf("expected compacted log in lin-kv but polled %v next at %v", stored.Messages["luck"], stored.NextOffsets)
+	}
+
+	if sent, _ := kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 7, SubKey: "a"}, ctx); sent.Offset != 6 {
+		t.Errorf("expected offset 6 after compaction but was %d", sent.Offset)
+	}
+}
+
+// failingCompaction is a logStorage whose compactions fail.
+type failingCompaction struct {
+	logStorage
+}
+
+func (s failingCompaction) Compact(ctx context.Context, key string, before recordLog, compacted recordLog, upTo int) error {
+	return maelstrom.NewRPCError(maelstrom.Crash, "compaction failed")
+}
+
+func TestFailedCompactionKeepsLog(t *testing.T) {
+	kafkaServer := newTestRetentionServer(t, []int{1, 2, 3, 4, 5, 6}, []string{"a", "b", "a", "a", "b", "c"})
+	kafkaServer.segments = failingCompaction{kafkaServer.segments}
+
+	clean(t, kafkaServer, RetentionPolicy{Compact: true})
+
+	p, _ := kafkaServer.partition("luck", false)
+	if len(p.log.records) != 6 || p.compactedTo != 0 {
+		t.Errorf("expected the log to stay uncompacted but was %v compacted to %d", p.log.records, p.compactedTo)
+	}
+
+	kafkaServer.segments = kafkaServer.segments.(failingCompaction).logStorage
+	kafkaServer.clean(context.TODO(), "luck", p)
+	if len(p.log.records) != 3 || p.compactedTo != 6 {
+		t.Errorf("expected the next run to compact the log but was %v compacted to %d", p.log.records, p.compactedTo)
+	}
+}
+
+func TestReplicateAppliesRetention(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	compacted := []Record{{Offset: 0, Value: 1, SubKey: "a"}, {Offset: 1, Value: 2, SubKey: "a"}, {Offset: 2, Value: 3}, {Offset: 3, Value: 4, SubKey: "a"}}
+
+	if _, err := kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", Records: compacted, End: 4, HighWatermark: 4, LogStart: 1, CompactedTo: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); !slices.Equal(messages, []Message{NewMessage(1, 2), NewMessage(2, 3), NewMessage(3, 4)}) {
+		t.Errorf("expected follower to trim and compact like the leader but had %v", messages)
+	}
+
+	// the follower missed records the leader no longer has
+	if _, err := kafkaServer.Replicate(&ReplicateMessage{MessageType: "replicate", Key: "luck", From: 10, Records: records(NewMessage(10, 11)), End: 11, HighWatermark: 11, LogStart: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages, _ := replicatedMessages(kafkaServer, "luck"); !slices.Equal(messages, []Message{NewMessage(10, 11)}) {
+		t.Errorf("expected follower to start over at the leader's log start but had %v", messages)
+	}
+}
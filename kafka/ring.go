@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const VIRTUAL_NODES int = 64
+
+// ring is a consistent-hash ring placing every node at VIRTUAL_NODES points,
+// so keys spread evenly and only the keys next to a node's points move when
+// it joins or leaves.
+type ring struct {
+	points []uint32
+	nodes  map[uint32]string
+}
+
+func newRing(nodes []string, virtualNodes int) *ring {
+	r := &ring{
+		points: make([]uint32, 0, len(nodes)*virtualNodes),
+		nodes:  make(map[uint32]string, len(nodes)*virtualNodes),
+	}
+
+	for _, node := range nodes {
+		for i := range virtualNodes {
+			point := hashKey(node + "#" + strconv.Itoa(i))
+			if _, ok := r.nodes[point]; ok {
+				continue
+			}
+			r.nodes[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	slices.Sort(r.points)
+
+	return r
+}
+
+// hashKey spreads keys that only differ in a suffix, like "n1#3" and "n1#4",
+// far better than fnv does.
+func hashKey(key string) uint32 {
+	sum := sha1.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// owners returns up to n distinct nodes clockwise from key's hash, the first
+// one is the key's preferred leader.
+func (r *ring) owners(key string, n int) []string {
+	owners := make([]string, 0, n)
+	if len(r.points) == 0 {
+		return owners
+	}
+
+	start, _ := slices.BinarySearch(r.points, hashKey(key))
+	for i := 0; i < len(r.points) && len(owners) < n; i++ {
+		node := r.nodes[r.points[(start+i)%len(r.points)]]
+		if !slices.Contains(owners, node) {
+			owners = append(owners, node)
+		}
+	}
+
+	return owners
+}
+
+// Owner reports the replicas of a key and the node leading it, which is the
+// lease holder if the lease is valid and the preferred leader otherwise.
+func (s *KafkaSever) Owner(msg *OwnerMessage, ctx context.Context) OwnerMessageReply {
+	replicas := s.replicas(msg.Key)
+	if l := s.currentLease(ctx, msg.Key); l.valid(time.Now()) {
+		return msg.Reply(l.Leader, replicas)
+	}
+	return msg.Reply(replicas[0], replicas)
+}
+
+// currentLease returns the lease of key without taking it over, the cached
+// one while it is valid.
+func (s *KafkaSever) currentLease(ctx context.Context, key string) lease {
+	l, ok := s.cachedLease(key)
+	if !ok || !l.valid(time.Now()) {
+		if err := s.linKV.ReadInto(ctx, leaseKey(key), &l); err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			log.Printf("failed to read lease of %s: %v", key, err)
+		}
+	}
+	return l
+}
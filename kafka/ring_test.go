@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+)
+
+func TestRingBalancesKeys(t *testing.T) {
+	r := newRing([]string{"n0", "n1", "n2", "n3", "n4"}, VIRTUAL_NODES)
+	keys := make(map[string]int)
+	for i := range 5000 {
+		keys[r.owners(fmt.Sprintf("key-%d", i), 1)[0]] += 1
+	}
+
+	if len(keys) != 5 {
+		t.Fatalf("expected keys on all 5 nodes but were on %v", keys)
+	}
+
+	for node, count := range keys {
+		if count < 500 || count > 1500 {
+			t.Errorf("%s owns %d of 5000 keys, expected around 1000", node, count)
+		}
+	}
+}
+
+func TestRingOwnershipStable(t *testing.T) {
+	before := newRing([]string{"n0", "n1", "n2", "n3"}, VIRTUAL_NODES)
+	after := newRing([]string{"n0", "n1", "n2", "n3", "n4"}, VIRTUAL_NODES)
+
+	moved := 0
+	for i := range 5000 {
+		key := fmt.Sprintf("key-%d", i)
+		from, to := before.owners(key, 1)[0], after.owners(key, 1)[0]
+		if from != to {
+			moved += 1
+			if to != "n4" {
+				t.Fatalf("%s moved from %s to %s instead of the new node", key, from, to)
+			}
+		}
+	}
+
+	if moved > 1750 {
+		t.Errorf("adding a fifth node moved %d of 5000 keys, expected around 1000", moved)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+
+	reply := kafkaServer.Owner(&OwnerMessage{MessageType: "owner", Key: "luck"}, ctx)
+	if reply.MessageType != "owner_ok" || reply.Key != "luck" || reply.Leader != "n0" {
+		t.Errorf("expected owner_ok with leader n0 but got %v", reply)
+	}
+
+	kafkaServer.linKV.Write(ctx, leaseKey("luck"), lease{Leader: "n7", Epoch: 2, Expires: 1 << 62})
+	if reply := kafkaServer.Owner(&OwnerMessage{MessageType: "owner", Key: "luck"}, ctx); reply.Leader != "n7" {
+		t.Errorf("expected the lease holder n7 to lead but was %s", reply.Leader)
+	}
+}
@@ -0,0 +1,265 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	SEGMENT_SIZE    int    = 100
+	HEAD_KEY_SUFFIX string = "/head"
+)
+
+// logSegment is the value of one segment of a key's log in lin-kv. Appends
+// only ever touch the tail segment, segments before the head are closed and
+// only rewritten by the cleaner. Epoch is the epoch of the leader that last
+// appended to it.
+type logSegment struct {
+	Epoch   int      `json:"epoch"`
+	Records []Record `json:"records"`
+}
+
+// logHead points at the newest segment known to exist, segments past it may
+// exist if a leader crashed before advancing it. Start is the log start
+// offset, records before it were removed by retention.
+type logHead struct {
+	Segment int `json:"segment"`
+	Start   int `json:"start,omitempty"`
+}
+
+// logStorage stores the logs of keys in segments of a fixed number of
+// records, retention and compaction work on whole segments. Append fails its
+// precondition if the cached log l is behind the stored log, like a lin-kv
+// compare-and-swap, and Read returns the log from the start of the segment
+// holding from together with the epoch of the tail segment.
+//
+// Storage shared by all nodes holds the leader's log, local storage is
+// written by followers as well through Mirror.
+type logStorage interface {
+	segmentStart(offset int) int
+	Append(ctx context.Context, key string, l recordLog, cachedEpoch int, epoch int, record Record) error
+	Read(ctx context.Context, key string, from int) (recordLog, int, error)
+	// TrimHead removes the records of the cached log l before start.
+	TrimHead(ctx context.Context, key string, l recordLog, start int, epoch int) error
+	// Truncate removes the records of the cached log l at or after end.
+	Truncate(ctx context.Context, key string, l recordLog, end int, epoch int) error
+	// Compact stores compacted, which compaction made of before, up to the
+	// segment holding upTo.
+	Compact(ctx context.Context, key string, before recordLog, compacted recordLog, upTo int) error
+	// Mirror stores a follower's log l, which changed from offset from.
+	Mirror(ctx context.Context, key string, l recordLog, from int, epoch int) error
+}
+
+// segmentStore keeps every key's log in lin-kv as segments of segmentSize
+// records under "<key>/<segment>" and a head pointer under "<key>/head".
+type segmentStore struct {
+	kv          kvstore.Store
+	segmentSize int
+}
+
+func newSegmentStore(kv kvstore.Store, segmentSize int) segmentStore {
+	return segmentStore{kv: kv, segmentSize: max(segmentSize, 1)}
+}
+
+func segmentKey(key string, segment int) string {
+	return fmt.Sprintf("%s/%d", key, segment)
+}
+
+func headKey(key string) string {
+	return key + HEAD_KEY_SUFFIX
+}
+
+// segmentStart returns the offset of the first record of the segment offset
+// is in.
+func (s segmentStore) segmentStart(offset int) int {
+	return offset - offset%s.segmentSize
+}
+
+// Append stores record at the end of l, the cached log of key whose tail
+// segment was last written at cachedEpoch. It fails its precondition if the
+// cache is behind lin-kv.
+func (s segmentStore) Append(ctx context.Context, key string, l recordLog, cachedEpoch int, epoch int, record Record) error {
+	start := s.segmentStart(l.end)
+	segment := start / s.segmentSize
+	tail := l.from(start)
+	from := logSegment{Epoch: cachedEpoch, Records: tail}
+	to := logSegment{Epoch: epoch, Records: append(tail[:len(tail):len(tail)], record)}
+	if err := s.kv.CompareAndSwap(ctx, segmentKey(key, segment), from, to, true); err != nil {
+		return err
+	}
+
+	if len(tail) == 0 && segment > 0 {
+		s.updateHead(ctx, key, func(head logHead) logHead {
+			head.Segment = max(head.Segment, segment)
+			return head
+		})
+	}
+	return nil
+}
+
+func (s segmentStore) readHead(ctx context.Context, key string) (logHead, error) {
+	head := logHead{}
+	if err := s.kv.ReadInto(ctx, headKey(key), &head); err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		return logHead{}, err
+	}
+	return head, nil
+}
+
+// updateHead is best effort for appends and the cleaner, readers probe past
+// a lagging head and the cleaner retries on its next run.
+func (s segmentStore) updateHead(ctx context.Context, key string, update func(logHead) logHead) error {
+	err := kvstore.Retry(ctx, kvstore.DefaultRetryPolicy, func(ctx context.Context) error {
+		head, err := s.readHead(ctx, key)
+		if err != nil {
+			return kvstore.Unavailable(err)
+		}
+
+		updated := update(head)
+		if updated == head {
+			return nil
+		}
+		return s.kv.CompareAndSwap(ctx, headKey(key), head, updated, true)
+	})
+	if err != nil {
+		log.Printf("failed to update head of %s: %v", key, err)
+	}
+	return err
+}
+
+// Read returns the log of key from the start of the segment holding from,
+// or from the log start if that is later, together with the epoch of the
+// tail segment. Segments up to the head are read without checking whether
+// they are full.
+func (s segmentStore) Read(ctx context.Context, key string, from int) (recordLog, int, error) {
+	head, err := s.readHead(ctx, key)
+	if err != nil {
+		return recordLog{}, 0, err
+	}
+
+	l := newRecordLog()
+	l.start = head.Start
+	l.end = s.segmentStart(max(from, head.Start))
+	epoch := 0
+	for segment := l.end / s.segmentSize; ; segment++ {
+		stored := logSegment{}
+		err := s.kv.ReadInto(ctx, segmentKey(key, segment), &stored)
+		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist && segment > head.Segment {
+			break
+		}
+
+		if err != nil {
+			return recordLog{}, 0, err
+		}
+
+		l.append(stored.Records, l.end)
+		if segment < head.Segment {
+			// closed segments cover their whole range even once compacted
+			l.end = (segment + 1) * s.segmentSize
+		} else if len(stored.Records) > 0 {
+			l.end = stored.Records[len(stored.Records)-1].Offset + 1
+		}
+		epoch = stored.Epoch
+		if len(stored.Records) < s.segmentSize && segment >= head.Segment {
+			break
+		}
+	}
+
+	l.records = l.from(head.Start)
+	// a deleted log starts at its old end, which may be in the tail segment
+	l.end = max(l.end, l.start)
+	return l, epoch, nil
+}
+
+// Write replaces a segment, used by the cleaner to store compacted or expired
+// closed segments at epoch zero and by admin requests to rewrite the tail
+// segment at the leader's epoch.
+func (s segmentStore) Write(ctx context.Context, key string, segment int, epoch int, records []Record) error {
+	return s.kv.Write(ctx, segmentKey(key, segment), logSegment{Epoch: epoch, Records: records})
+}
+
+// TrimHead moves the log start in the head, so readers skip the records
+// before it, and empties the segments before it. A log start inside the tail
+// segment is only left by deletes, the tail keeps its records after start so
+// appends find it as they cached it.
+func (s segmentStore) TrimHead(ctx context.Context, key string, l recordLog, start int, epoch int) error {
+	err := s.updateHead(ctx, key, func(head logHead) logHead {
+		head.Start = max(head.Start, start)
+		return head
+	})
+	if err != nil {
+		return err
+	}
+
+	for segment := l.start / s.segmentSize; (segment+1)*s.segmentSize <= start; segment++ {
+		if err := s.Write(ctx, key, segment, 0, make([]Record, 0)); err != nil {
+			log.Printf("failed to remove segment %d of %s: %v", segment, key, err)
+			return err
+		}
+	}
+
+	if segment := start / s.segmentSize; start%s.segmentSize != 0 {
+		segmentEpoch := 0
+		if segment == l.end/s.segmentSize {
+			segmentEpoch = epoch
+		}
+		return s.Write(ctx, key, segment, segmentEpoch, l.upTo((segment+1)*s.segmentSize).from(start))
+	}
+	return nil
+}
+
+// Truncate moves the head back to the segment holding end first, so readers
+// stop there, then rewrites it at epoch and empties the segments after it.
+func (s segmentStore) Truncate(ctx context.Context, key string, l recordLog, end int, epoch int) error {
+	tail := s.segmentStart(end)
+	err := s.updateHead(ctx, key, func(head logHead) logHead {
+		head.Segment = tail / s.segmentSize
+		return head
+	})
+	if err != nil {
+		return err
+	}
+
+	for segment := tail / s.segmentSize; segment <= l.end/s.segmentSize; segment++ {
+		records := make([]Record, 0)
+		if segment == tail/s.segmentSize {
+			records = l.upTo(end).from(tail)
+		}
+		if err := s.Write(ctx, key, segment, epoch, records); err != nil {
+			log.Printf("failed to truncate segment %d of %s: %v", segment, key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact rewrites the closed segments before upTo that lost records.
+func (s segmentStore) Compact(ctx context.Context, key string, before recordLog, compacted recordLog, upTo int) error {
+	head, err := s.readHead(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for offset := s.segmentStart(compacted.start); offset < min(upTo, head.Segment*s.segmentSize); offset += s.segmentSize {
+		segment := before.upTo(offset + s.segmentSize).from(offset)
+		records := compacted.upTo(offset + s.segmentSize).from(offset)
+		if len(segment) == len(records) {
+			continue
+		}
+
+		if err := s.Write(ctx, key, offset/s.segmentSize, 0, records); err != nil {
+			log.Printf("failed to compact segment %d of %s: %v", offset/s.segmentSize, key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Mirror has nothing to do, lin-kv already holds the leader's log.
+func (s segmentStore) Mirror(ctx context.Context, key string, l recordLog, from int, epoch int) error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestSegmentStoreAppend(t *testing.T) {
+	linKV := kvstore.NewMemoryStore()
+	segments := newSegmentStore(linKV, 2)
+	ctx := context.TODO()
+
+	l := newRecordLog()
+	for offset := range 5 {
+		record := Record{Offset: offset, Value: offset * 10}
+		if err := segments.Append(ctx, "luck", l, 1, 1, record); err != nil {
+			t.Fatal(err)
+		}
+		l.append([]Record{record}, offset+1)
+	}
+
+	for segment, expected := range [][]Message{{NewMessage(0, 0), NewMessage(1, 10)}, {NewMessage(2, 20), NewMessage(3, 30)}, {NewMessage(4, 40)}} {
+		stored := logSegment{}
+		if err := linKV.ReadInto(ctx, segmentKey("luck", segment), &stored); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(toMessages(stored.Records), expected) {
+			t.Errorf("segment %d expected %v but was %v", segment, expected, toMessages(stored.Records))
+		}
+	}
+
+	head := logHead{}
+	if err := linKV.ReadInto(ctx, headKey("luck"), &head); err != nil || head.Segment != 2 {
+		t.Errorf("expected head at segment 2 but was %v: %v", head, err)
+	}
+
+	// a stale cache fails the precondition instead of overwriting the tail
+	err := segments.Append(ctx, "luck", l.upTo(4), 1, 1, Record{Offset: 4, Value: 41})
+	if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected append from a stale cache to fail its precondition but got %v", err)
+	}
+}
+
+func TestSegmentStoreRead(t *testing.T) {
+	linKV := kvstore.NewMemoryStore()
+	segments := newSegmentStore(linKV, 2)
+	ctx := context.TODO()
+	linKV.Write(ctx, segmentKey("luck", 0), logSegment{Epoch: 1, Records: records(NewMessage(0, 0), NewMessage(1, 10))})
+	linKV.Write(ctx, segmentKey("luck", 1), logSegment{Epoch: 1, Records: records(NewMessage(2, 20), NewMessage(3, 30))})
+	// the head wasn't advanced to the tail
+	linKV.Write(ctx, segmentKey("luck", 2), logSegment{Epoch: 2, Records: records(NewMessage(4, 40))})
+	linKV.Write(ctx, headKey("luck"), logHead{Segment: 1})
+
+	l, epoch, err := segments.Read(ctx, "luck", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.end != 5 || epoch != 2 || !slices.Equal(toMessages(l.records), []Message{NewMessage(2, 20), NewMessage(3, 30), NewMessage(4, 40)}) {
+		t.Errorf("expected records from offset 2 up to 5 at epoch 2 but got %v up to %d at epoch %d", l.records, l.end, epoch)
+	}
+
+	if _, _, err := segments.Read(ctx, "prize", 0); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Errorf("expected missing log to not exist but got %v", err)
+	}
+}
+
+func TestPollReadsSegmentsFromLinKV(t *testing.T) {
+	linKV := kvstore.NewMemoryStore()
+	leader := newTestKafkaServer(kvstore.NewMemoryStore())
+	leader.linKV, leader.segments = linKV, newSegmentStore(linKV, 2)
+	ctx := context.TODO()
+	for value := range 5 {
+		if _, err := leader.Send(&SendMessage{MessageType: "send", Key: "luck", Value: value}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := newTestKafkaServer(kvstore.NewMemoryStore())
+	reader.linKV, reader.segments = linKV, newSegmentStore(linKV, 2)
+	reply, _ := reader.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 3}}, ctx)
+
+	if !slices.Equal(reply.Messages["luck"], []Message{NewMessage(3, 3), NewMessage(4, 4)}) {
+		t.Errorf("expected records from offset 3 but polled %v", reply.Messages["luck"])
+	}
+}
@@ -0,0 +1,414 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	TXN_KEY_PREFIX      string        = "txn-"
+	TRANSACTION_TIMEOUT time.Duration = 10 * time.Second
+	READ_COMMITTED      string        = "read_committed"
+)
+
+const (
+	TXN_ONGOING         string = "ongoing"
+	TXN_PREPARE_COMMIT  string = "prepare_commit"
+	TXN_PREPARE_ABORT   string = "prepare_abort"
+	TXN_COMPLETE_COMMIT string = "complete_commit"
+	TXN_COMPLETE_ABORT  string = "complete_abort"
+)
+
+const (
+	COMMIT_MARKER string = "commit"
+	ABORT_MARKER  string = "abort"
+)
+
+// txnState is the value of a transactional id in lin-kv, the epoch of its
+// latest transaction, how far that transaction got and the keys it wrote to.
+// Once a transaction is prepared the outcome is decided, what is left is
+// writing its markers.
+type txnState struct {
+	Epoch  int      `json:"epoch"`
+	Status string   `json:"status"`
+	Keys   []string `json:"keys"`
+}
+
+// transaction is the coordinator's view of a transactional id, loaded while
+// holding the lease of the id at leaseEpoch. When the transaction started is
+// only kept in memory, transactions loaded by a new coordinator get a fresh
+// timeout.
+type transaction struct {
+	lock       *sync.Mutex
+	loaded     bool
+	leaseEpoch int
+	state      txnState
+	started    time.Time
+}
+
+func txnKey(id string) string {
+	return TXN_KEY_PREFIX + id
+}
+
+// transaction returns the locked state of id for the coordinator at
+// leaseEpoch, loading it from lin-kv the first time, once the lease changed
+// hands and after a failed store.
+func (s *KafkaSever) transaction(ctx context.Context, id string, leaseEpoch int) (*transaction, error) {
+	s.lock.Lock()
+	t, ok := s.transactions[id]
+	if !ok {
+		t = &transaction{lock: &sync.Mutex{}}
+		s.transactions[id] = t
+	}
+	s.lock.Unlock()
+
+	t.lock.Lock()
+	if t.loaded && t.leaseEpoch == leaseEpoch {
+		return t, nil
+	}
+
+	state := txnState{Keys: make([]string, 0)}
+	if err := s.linKV.ReadInto(ctx, txnKey(id), &state); err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.lock.Unlock()
+		return nil, kvstore.Unavailable(err)
+	}
+
+	if t.leaseEpoch != leaseEpoch || t.state.Epoch != state.Epoch {
+		t.started = time.Now()
+	}
+	t.state, t.leaseEpoch, t.loaded = state, leaseEpoch, true
+	return t, nil
+}
+
+// store persists state as the state of id if the stored state is still the
+// one loaded, so a former coordinator can't overwrite the decisions of its
+// successor. The caller holds t.lock.
+func (s *KafkaSever) store(ctx context.Context, id string, t *transaction, state txnState) error {
+	if err := s.linKV.CompareAndSwap(ctx, txnKey(id), t.state, state, true); err != nil {
+		log.Printf("failed to store transaction %s in epoch %d as %s: %v", id, state.Epoch, state.Status, err)
+		// the stored state changed or is unknown, the next request reloads it
+		t.loaded = false
+		return kvstore.Unavailable(err)
+	}
+
+	t.state = state
+	return nil
+}
+
+// BeginTxn starts the next transaction of a transactional id. An open
+// transaction of the id is aborted and a prepared one completed first, so a
+// restarted producer fences its earlier self.
+func (s *KafkaSever) BeginTxn(msg *BeginTxnMessage, ctx context.Context) (BeginTxnMessageReply, error) {
+	l, ok, err := s.coordinatorFor(ctx, txnKey(msg.TransactionalID))
+	if err != nil {
+		return BeginTxnMessageReply{}, err
+	}
+	if !ok {
+		return forwardToLeader[BeginTxnMessageReply](ctx, s, txnKey(msg.TransactionalID), l, msg)
+	}
+
+	t, err := s.transaction(ctx, msg.TransactionalID, l.Epoch)
+	if err != nil {
+		return BeginTxnMessageReply{}, err
+	}
+	defer t.lock.Unlock()
+
+	if err := s.finish(ctx, msg.TransactionalID, t); err != nil {
+		return BeginTxnMessageReply{}, err
+	}
+
+	state := txnState{Epoch: t.state.Epoch + 1, Status: TXN_ONGOING, Keys: make([]string, 0)}
+	if err := s.store(ctx, msg.TransactionalID, t, state); err != nil {
+		return BeginTxnMessageReply{}, err
+	}
+
+	t.started = time.Now()
+	return msg.Reply(state.Epoch), nil
+}
+
+// AddTxnKey registers key with the open transaction of msg, which is
+// rejected if the transaction is no longer open.
+func (s *KafkaSever) AddTxnKey(msg *AddTxnKeyMessage, ctx context.Context) (AddTxnKeyMessageReply, error) {
+	l, ok, err := s.coordinatorFor(ctx, txnKey(msg.TransactionalID))
+	if err != nil {
+		return AddTxnKeyMessageReply{}, err
+	}
+	if !ok {
+		return forwardToLeader[AddTxnKeyMessageReply](ctx, s, txnKey(msg.TransactionalID), l, msg)
+	}
+
+	t, err := s.transaction(ctx, msg.TransactionalID, l.Epoch)
+	if err != nil {
+		return AddTxnKeyMessageReply{}, err
+	}
+	defer t.lock.Unlock()
+
+	if err := t.validate(msg.TransactionalID, msg.TxnEpoch); err != nil {
+		return AddTxnKeyMessageReply{}, err
+	}
+
+	if !slices.Contains(t.state.Keys, msg.Key) {
+		state := t.state
+		state.Keys = append(slices.Clone(state.Keys), msg.Key)
+		if err := s.store(ctx, msg.TransactionalID, t, state); err != nil {
+			return AddTxnKeyMessageReply{}, err
+		}
+	}
+
+	return msg.Reply(), nil
+}
+
+// EndTxn commits or aborts a transaction. The outcome is decided once the
+// transaction is prepared in lin-kv, the markers are then appended to every
+// key it wrote to. A retried commit or abort completes the markers.
+func (s *KafkaSever) EndTxn(msg *EndTxnMessage, ctx context.Context) (EndTxnMessageReply, error) {
+	l, ok, err := s.coordinatorFor(ctx, txnKey(msg.TransactionalID))
+	if err != nil {
+		return EndTxnMessageReply{}, err
+	}
+	if !ok {
+		return forwardToLeader[EndTxnMessageReply](ctx, s, txnKey(msg.TransactionalID), l, msg)
+	}
+
+	t, err := s.transaction(ctx, msg.TransactionalID, l.Epoch)
+	if err != nil {
+		return EndTxnMessageReply{}, err
+	}
+	defer t.lock.Unlock()
+
+	prepared, completed := TXN_PREPARE_ABORT, TXN_COMPLETE_ABORT
+	if msg.MessageType == "commit_txn" {
+		prepared, completed = TXN_PREPARE_COMMIT, TXN_COMPLETE_COMMIT
+	}
+
+	if t.state.Epoch == msg.TxnEpoch && (t.state.Status == prepared || t.state.Status == completed) {
+		// a retry
+		if err := s.finish(ctx, msg.TransactionalID, t); err != nil {
+			return EndTxnMessageReply{}, err
+		}
+		return msg.Reply(), nil
+	}
+
+	if err := t.validate(msg.TransactionalID, msg.TxnEpoch); err != nil {
+		return EndTxnMessageReply{}, err
+	}
+
+	if err := s.end(ctx, msg.TransactionalID, t, prepared); err != nil {
+		return EndTxnMessageReply{}, err
+	}
+	return msg.Reply(), nil
+}
+
+// validate rejects writes to transactions that were fenced by a newer one or
+// already ended. The caller holds t.lock.
+func (t *transaction) validate(id string, epoch int) error {
+	if epoch != t.state.Epoch {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("epoch %d of transaction %s is fenced, current epoch is %d", epoch, id, t.state.Epoch))
+	}
+
+	if t.state.Status != TXN_ONGOING {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("transaction %s in epoch %d is not open but %s", id, epoch, t.state.Status))
+	}
+
+	return nil
+}
+
+// end prepares the open transaction of id to commit or abort and writes its
+// markers. The caller holds t.lock.
+func (s *KafkaSever) end(ctx context.Context, id string, t *transaction, prepared string) error {
+	state := t.state
+	state.Status = prepared
+	if err := s.store(ctx, id, t, state); err != nil {
+		return err
+	}
+
+	log.Printf("transaction %s in epoch %d is %s", id, state.Epoch, prepared)
+	return s.finish(ctx, id, t)
+}
+
+// finish brings the latest transaction of id to an end, aborting it if it's
+// still open and writing the markers of a prepared one. The caller holds
+// t.lock.
+func (s *KafkaSever) finish(ctx context.Context, id string, t *transaction) error {
+	marker, completed := COMMIT_MARKER, TXN_COMPLETE_COMMIT
+	switch t.state.Status {
+	case TXN_ONGOING:
+		return s.end(ctx, id, t, TXN_PREPARE_ABORT)
+	case TXN_PREPARE_COMMIT:
+	case TXN_PREPARE_ABORT:
+		marker, completed = ABORT_MARKER, TXN_COMPLETE_ABORT
+	default:
+		return nil
+	}
+
+	errs := make(chan error, len(t.state.Keys))
+	for _, key := range t.state.Keys {
+		go func() {
+			_, err := s.WriteMarker(&WriteMarkerMessage{MessageType: "write_marker", Key: key, TransactionalID: id, TxnEpoch: t.state.Epoch, Control: marker}, ctx)
+			if err != nil {
+				log.Printf("failed to write %s marker of transaction %s to %s: %v", marker, id, key, err)
+			}
+			errs <- err
+		}()
+	}
+
+	for range t.state.Keys {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	state := t.state
+	state.Status = completed
+	return s.store(ctx, id, t, state)
+}
+
+// WriteMarker appends the commit or abort marker of a transaction to a key,
+// forwarding it to the key's leader like sends. Only coordinators finishing
+// a transaction send it, clients can't send markers.
+func (s *KafkaSever) WriteMarker(msg *WriteMarkerMessage, ctx context.Context) (WriteMarkerMessageReply, error) {
+	if msg.Control != COMMIT_MARKER && msg.Control != ABORT_MARKER {
+		return WriteMarkerMessageReply{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("unknown transaction marker %q", msg.Control))
+	}
+
+	l, err := s.leaderFor(ctx, msg.Key)
+	if err != nil {
+		return WriteMarkerMessageReply{}, err
+	}
+
+	if l.Leader != s.node.ID() || !l.valid(time.Now()) {
+		return forwardToLeader[WriteMarkerMessageReply](ctx, s, msg.Key, l, msg)
+	}
+
+	record := Record{TransactionalID: msg.TransactionalID, TxnEpoch: msg.TxnEpoch, Control: msg.Control}
+	offset, err := s.appendAsLeader(ctx, msg.Key, l.Epoch, s.followers(msg.Key), record)
+	if err != nil {
+		return WriteMarkerMessageReply{}, err
+	}
+
+	return msg.Reply(offset), nil
+}
+
+// addToTxn registers key with the transaction msg is sent in before its
+// record is appended.
+func (s *KafkaSever) addToTxn(ctx context.Context, msg *SendMessage) error {
+	add := &AddTxnKeyMessage{MessageType: "add_txn_key", TransactionalID: msg.TransactionalID, TxnEpoch: msg.TxnEpoch, Key: msg.Key}
+	_, err := s.AddTxnKey(add, ctx)
+	return err
+}
+
+// checkTxn rejects records of transactions whose marker is already in the
+// log, they would otherwise never be decided. The caller holds p.lock.
+func (p *partition) checkTxn(record Record) error {
+	if record.TransactionalID == "" || record.Control != "" {
+		return nil
+	}
+
+	if ended, ok := p.endedTxns[record.TransactionalID]; ok && ended >= record.TxnEpoch {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("transaction %s in epoch %d already ended", record.TransactionalID, record.TxnEpoch))
+	}
+	return nil
+}
+
+// TransactionReaper aborts the transactions this node coordinates that were
+// left open for TRANSACTION_TIMEOUT and completes the ones whose markers
+// couldn't be written.
+func (s *KafkaSever) TransactionReaper(ctx context.Context) {
+	ticker := time.NewTicker(TRANSACTION_TIMEOUT / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.lock.RLock()
+			transactions := maps.Clone(s.transactions)
+			s.lock.RUnlock()
+
+			for id := range transactions {
+				s.reapTransaction(ctx, id)
+			}
+		}
+	}
+}
+
+// reapTransaction ends the transaction of id if it timed out or its markers
+// are missing while this node coordinates id, keeping its lease.
+func (s *KafkaSever) reapTransaction(ctx context.Context, id string) {
+	ctx, cancel := context.WithTimeout(ctx, TRANSACTION_TIMEOUT/2)
+	defer cancel()
+	l, ok, err := s.coordinatorFor(ctx, txnKey(id))
+	if err != nil || !ok {
+		return
+	}
+
+	t, err := s.transaction(ctx, id, l.Epoch)
+	if err != nil {
+		return
+	}
+	defer t.lock.Unlock()
+
+	if t.state.Status == TXN_ONGOING && time.Since(t.started) < TRANSACTION_TIMEOUT {
+		return
+	}
+
+	if t.state.Status == TXN_ONGOING {
+		log.Printf("aborting transaction %s in epoch %d after %v", id, t.state.Epoch, TRANSACTION_TIMEOUT)
+	}
+	s.finish(ctx, id, t)
+}
+
+// readCommitted returns the records of a read committed poll among records,
+// which run up to the end of l, and the last stable offset, the first offset
+// of a transaction that isn't decided yet or the end of the log. Records of
+// aborted transactions are left out.
+func readCommitted(l recordLog, records []Record) ([]Record, int) {
+	markers := make(map[string]map[int]string)
+	for _, record := range records {
+		if record.Control != "" {
+			if markers[record.TransactionalID] == nil {
+				markers[record.TransactionalID] = make(map[int]string)
+			}
+			markers[record.TransactionalID][record.TxnEpoch] = record.Control
+		}
+	}
+
+	stable := l.end
+	committed := make([]Record, 0, len(records))
+	for _, record := range records {
+		if record.Control != "" {
+			continue
+		}
+
+		if record.TransactionalID != "" {
+			marker, ok := markers[record.TransactionalID][record.TxnEpoch]
+			if !ok {
+				stable = record.Offset
+				break
+			}
+			if marker != COMMIT_MARKER {
+				continue
+			}
+		}
+		committed = append(committed, record)
+	}
+
+	return committed, stable
+}
+
+// readUncommitted returns records without the control records.
+func readUncommitted(records []Record) []Record {
+	if !slices.ContainsFunc(records, func(record Record) bool { return record.Control != "" }) {
+		return records
+	}
+	return slices.DeleteFunc(slices.Clone(records), func(record Record) bool { return record.Control != "" })
+}
@@ -0,0 +1,188 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func beginTxn(t *testing.T, s *KafkaSever, id string) int {
+	reply, err := s.BeginTxn(&BeginTxnMessage{MessageType: "begin_txn", TransactionalID: id}, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply.TxnEpoch
+}
+
+func sendInTxn(s *KafkaSever, key string, value int, id string, epoch int) error {
+	_, err := s.Send(&SendMessage{MessageType: "send", Key: key, Value: value, TransactionalID: id, TxnEpoch: epoch}, context.TODO())
+	return err
+}
+
+func TestCommitTxn(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	epoch := beginTxn(t, kafkaServer, "outbox")
+
+	kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 10}, ctx)
+	for key, value := range map[string]int{"luck": 11, "fortune": 20} {
+		if err := sendInTxn(kafkaServer, key, value, "outbox", epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 12}, ctx)
+
+	poll := PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0, "fortune": 0}, IsolationLevel: READ_COMMITTED}
+	reply, _ := kafkaServer.Poll(&poll, ctx)
+	if len(reply.Messages["luck"]) != 1 || len(reply.Messages["fortune"]) != 0 {
+		t.Errorf("expected only the record before the open transaction but got %v", reply.Messages)
+	}
+	if reply.NextOffsets["luck"] != 1 || reply.NextOffsets["fortune"] != 0 {
+		t.Errorf("expected next offsets to stop at the open transaction but got %v", reply.NextOffsets)
+	}
+
+	uncommitted, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}}, ctx)
+	if len(uncommitted.Messages["luck"]) != 3 {
+		t.Errorf("expected read uncommitted polls to see the open transaction but got %v", uncommitted.Messages)
+	}
+
+	if _, err := kafkaServer.EndTxn(&EndTxnMessage{MessageType: "commit_txn", TransactionalID: "outbox", TxnEpoch: epoch}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, _ = kafkaServer.Poll(&poll, ctx)
+	expected := map[string][]Message{"luck": {{0, 10}, {1, 11}, {2, 12}}, "fortune": {{0, 20}}}
+	for key, messages := range expected {
+		if len(reply.Messages[key]) != len(messages) {
+			t.Fatalf("expected %v of %s once committed but got %v", messages, key, reply.Messages[key])
+		}
+		for i, message := range messages {
+			if reply.Messages[key][i] != message {
+				t.Errorf("expected %v at %d of %s but got %v", message, i, key, reply.Messages[key][i])
+			}
+		}
+	}
+
+	// next offsets skip the commit markers
+	if reply.NextOffsets["luck"] != 4 || reply.NextOffsets["fortune"] != 2 {
+		t.Errorf("expected next offsets past the markers but got %v", reply.NextOffsets)
+	}
+
+	if _, err := kafkaServer.EndTxn(&EndTxnMessage{MessageType: "commit_txn", TransactionalID: "outbox", TxnEpoch: epoch}, ctx); err != nil {
+		t.Errorf("expected a retried commit to succeed but got %v", err)
+	}
+
+	if err := sendInTxn(kafkaServer, "luck", 13, "outbox", epoch); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected sends to a committed transaction to be rejected but got %v", err)
+	}
+}
+
+func TestAbortTxn(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	epoch := beginTxn(t, kafkaServer, "outbox")
+	sendInTxn(kafkaServer, "luck", 11, "outbox", epoch)
+
+	if _, err := kafkaServer.EndTxn(&EndTxnMessage{MessageType: "abort_txn", TransactionalID: "outbox", TxnEpoch: epoch}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	kafkaServer.Send(&SendMessage{MessageType: "send", Key: "luck", Value: 12}, ctx)
+
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}, IsolationLevel: READ_COMMITTED}, ctx)
+	if messages := reply.Messages["luck"]; len(messages) != 1 || messages[0] != NewMessage(2, 12) {
+		t.Errorf("expected aborted records to be hidden but got %v", messages)
+	}
+
+	if _, err := kafkaServer.EndTxn(&EndTxnMessage{MessageType: "commit_txn", TransactionalID: "outbox", TxnEpoch: epoch}, ctx); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected committing an aborted transaction to fail but got %v", err)
+	}
+}
+
+func TestSendRejectsForgedMarkers(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	epoch := beginTxn(t, kafkaServer, "outbox")
+	sendInTxn(kafkaServer, "luck", 11, "outbox", epoch)
+
+	forged := &SendMessage{MessageType: "send", Key: "luck", TransactionalID: "outbox", TxnEpoch: epoch, Control: COMMIT_MARKER}
+	if _, err := kafkaServer.Send(forged, ctx); maelstrom.ErrorCode(err) != maelstrom.MalformedRequest {
+		t.Errorf("expected a send with a marker to be malformed but got %v", err)
+	}
+
+	entries := []SendBatchEntry{{Key: "luck", Value: 12}, {Key: "luck", Control: ABORT_MARKER}}
+	reply := kafkaServer.SendBatch(&SendBatchMessage{MessageType: "send_batch", Entries: entries}, ctx)
+	if reply.Results[0].Error != nil || reply.Results[1].Error == nil || reply.Results[1].Error.Code != maelstrom.MalformedRequest {
+		t.Errorf("expected only the entry with a marker to fail but got %+v", reply.Results)
+	}
+
+	// the transaction is still open
+	polled, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}, IsolationLevel: READ_COMMITTED}, ctx)
+	if messages := polled.Messages["luck"]; len(messages) != 0 {
+		t.Errorf("expected no committed records but got %v", messages)
+	}
+
+	if _, err := kafkaServer.WriteMarker(&WriteMarkerMessage{MessageType: "write_marker", Key: "luck", TransactionalID: "outbox", TxnEpoch: epoch, Control: "commit_ish"}, ctx); maelstrom.ErrorCode(err) != maelstrom.MalformedRequest {
+		t.Errorf("expected an unknown marker to be malformed but got %v", err)
+	}
+}
+
+func TestBeginTxnFencesOpenTxn(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	epoch := beginTxn(t, kafkaServer, "outbox")
+	sendInTxn(kafkaServer, "luck", 11, "outbox", epoch)
+
+	next := beginTxn(t, kafkaServer, "outbox")
+	if next != epoch+1 {
+		t.Errorf("expected epoch %d but got %d", epoch+1, next)
+	}
+
+	if err := sendInTxn(kafkaServer, "luck", 12, "outbox", epoch); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected sends of a fenced epoch to be rejected but got %v", err)
+	}
+
+	reply, _ := kafkaServer.Poll(&PollMessage{MessageType: "poll", Offsets: Offsets{"luck": 0}, IsolationLevel: READ_COMMITTED}, ctx)
+	if len(reply.Messages["luck"]) != 0 || reply.NextOffsets["luck"] != 2 {
+		t.Errorf("expected the open transaction to be aborted but got %v with next offsets %v", reply.Messages, reply.NextOffsets)
+	}
+}
+
+func TestCheckTxn(t *testing.T) {
+	p := newPartition()
+	p.observeProducers([]Record{{Offset: 0, TransactionalID: "outbox", TxnEpoch: 1, Value: 11}, {Offset: 1, TransactionalID: "outbox", TxnEpoch: 1, Control: COMMIT_MARKER}})
+
+	if err := p.checkTxn(Record{TransactionalID: "outbox", TxnEpoch: 1}); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected a record of an ended transaction to be rejected but got %v", err)
+	}
+
+	if err := p.checkTxn(Record{TransactionalID: "outbox", TxnEpoch: 2}); err != nil {
+		t.Errorf("expected a record of the next transaction to be accepted but got %v", err)
+	}
+}
+
+func TestEndTxnReloadsAfterConflictingStore(t *testing.T) {
+	kafkaServer := newTestKafkaServer(kvstore.NewMemoryStore())
+	ctx := context.TODO()
+	epoch := beginTxn(t, kafkaServer, "outbox")
+
+	// a former coordinator began the next transaction this one never loaded
+	kafkaServer.linKV.Write(ctx, txnKey("outbox"), txnState{Epoch: epoch + 1, Status: TXN_ONGOING, Keys: []string{}})
+
+	commit := EndTxnMessage{MessageType: "commit_txn", TransactionalID: "outbox", TxnEpoch: epoch}
+	if _, err := kafkaServer.EndTxn(&commit, ctx); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected commit on a stale transaction to fail but got %v", err)
+	}
+
+	if _, err := kafkaServer.EndTxn(&commit, ctx); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Errorf("expected reloaded transaction to fence epoch %d but got %v", epoch, err)
+	}
+
+	stored := txnState{}
+	kafkaServer.linKV.ReadInto(ctx, txnKey("outbox"), &stored)
+	if stored.Epoch != epoch+1 || stored.Status != TXN_ONGOING {
+		t.Errorf("expected epoch %d to stay open but got %v", epoch+1, stored)
+	}
+}
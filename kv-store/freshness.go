@@ -0,0 +1,180 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	BARRIER_KEY_PREFIX  string = "barrier-"
+	STABLE_READS        int    = 2
+	MAX_FRESHNESS_READS int    = 10
+	BARRIER_FRESHNESS   string = "barrier"
+	STABLE_FRESHNESS    string = "stable"
+	SESSION_FRESHNESS   string = "session"
+)
+
+// Freshness decides how hard a read from a sequentially consistent store
+// tries to not return a stale value. Observe is called with every value the
+// node wrote, for strategies that track what the node has already seen.
+type Freshness interface {
+	Read(ctx context.Context, store Store, key string) (any, error)
+	Observe(key string, value int)
+}
+
+func NewFreshness(name string, n *maelstrom.Node) Freshness {
+	switch name {
+	case STABLE_FRESHNESS:
+		return NewStableFreshness(STABLE_READS, MAX_FRESHNESS_READS)
+	case SESSION_FRESHNESS:
+		return NewSessionFreshness(MAX_FRESHNESS_READS)
+	default:
+		return NewBarrierFreshness(n)
+	}
+}
+
+func ReadInt(ctx context.Context, freshness Freshness, store Store, key string) (int, error) {
+	v, err := freshness.Read(ctx, store, key)
+	i, _ := v.(int)
+	return i, err
+}
+
+func ReadInto(ctx context.Context, freshness Freshness, store Store, key string, v any) error {
+	value, err := freshness.Read(ctx, store, key)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// BarrierFreshness writes a new value to a key owned by the node before every
+// read. The store can't serve the write from a stale state, so the read that
+// follows it sees everything that completed before the barrier. Reusing one
+// key per node avoids leaving a garbage key behind for every read.
+type BarrierFreshness struct {
+	n       *maelstrom.Node
+	lock    *sync.Mutex
+	barrier int
+}
+
+func NewBarrierFreshness(n *maelstrom.Node) *BarrierFreshness {
+	return &BarrierFreshness{n: n, lock: &sync.Mutex{}}
+}
+
+func (f *BarrierFreshness) Read(ctx context.Context, store Store, key string) (any, error) {
+	f.lock.Lock()
+	f.barrier += 1
+	barrier := f.barrier
+	f.lock.Unlock()
+
+	if err := store.Write(ctx, BARRIER_KEY_PREFIX+f.n.ID(), barrier); err != nil {
+		log.Printf("failed to write barrier %d: %v", barrier, err)
+		return nil, err
+	}
+
+	return store.Read(ctx, key)
+}
+
+func (f *BarrierFreshness) Observe(key string, value int) {}
+
+// StableFreshness reads the key until the same value comes back for
+// stableReads reads in a row. It never writes but is only probabilistically
+// fresh, a stale value can repeat.
+type StableFreshness struct {
+	stableReads int
+	maxReads    int
+}
+
+func NewStableFreshness(stableReads int, maxReads int) *StableFreshness {
+	return &StableFreshness{stableReads: stableReads, maxReads: max(maxReads, stableReads)}
+}
+
+func (f *StableFreshness) Read(ctx context.Context, store Store, key string) (any, error) {
+	var previous []byte
+	var value any
+	repeated := 0
+	for range f.maxReads {
+		current, err := store.Read(ctx, key)
+		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			return nil, err
+		}
+
+		raw, _ := json.Marshal(current)
+		if previous != nil && string(raw) == string(previous) {
+			repeated += 1
+		} else {
+			repeated = 1
+		}
+
+		previous, value = raw, current
+		if repeated >= f.stableReads {
+			return value, err
+		}
+	}
+
+	log.Printf("reads of %s did not stabilise after %d reads", key, f.maxReads)
+	return value, nil
+}
+
+func (f *StableFreshness) Observe(key string, value int) {}
+
+// SessionFreshness gives read-your-writes for monotonically growing integer
+// values such as counters and offsets. The highest value the node wrote or
+// read for a key is its session token, and reads are retried until they catch
+// up with it.
+type SessionFreshness struct {
+	lock     *sync.Mutex
+	tokens   map[string]int
+	maxReads int
+}
+
+func NewSessionFreshness(maxReads int) *SessionFreshness {
+	return &SessionFreshness{lock: &sync.Mutex{}, tokens: make(map[string]int), maxReads: max(maxReads, 1)}
+}
+
+func (f *SessionFreshness) token(key string) (int, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	token, ok := f.tokens[key]
+	return token, ok
+}
+
+func (f *SessionFreshness) Read(ctx context.Context, store Store, key string) (any, error) {
+	token, ok := f.token(key)
+	for range f.maxReads {
+		value, err := store.Read(ctx, key)
+		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			return nil, err
+		}
+
+		current, isInt := value.(int)
+		if !ok || (err == nil && isInt && current >= token) {
+			if isInt {
+				f.Observe(key, current)
+			}
+			return value, err
+		}
+
+		log.Printf("read %v of %s behind session token %d", value, key, token)
+	}
+
+	return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("read of %s did not catch up with session token %d", key, token))
+}
+
+func (f *SessionFreshness) Observe(key string, value int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if token, ok := f.tokens[key]; !ok || value > token {
+		f.tokens[key] = value
+	}
+}
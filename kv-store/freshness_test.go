@@ -0,0 +1,76 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func staleCounter(t *testing.T, writes int) (*StaleStore, context.Context) {
+	store := NewStaleStore()
+	writer := store.Client()
+	ctx := context.TODO()
+	for value := 1; value <= writes; value++ {
+		if err := writer.Write(ctx, "counter", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, ctx
+}
+
+func TestStaleStoreReturnsStaleValues(t *testing.T) {
+	store, ctx := staleCounter(t, 3)
+
+	_, err := store.Client().ReadInt(ctx, "counter")
+
+	if maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("expected the first read of a new client to be stale but got %v", err)
+	}
+}
+
+func TestBarrierFreshness(t *testing.T) {
+	store, ctx := staleCounter(t, 3)
+	n := maelstrom.NewNode()
+	n.Init("n1", []string{"n1"})
+	freshness := NewBarrierFreshness(n)
+	reader := store.Client()
+
+	for range 3 {
+		if value, err := ReadInt(ctx, freshness, reader, "counter"); err != nil || value != 3 {
+			t.Fatalf("expected fresh value 3 but got %d, %v", value, err)
+		}
+	}
+
+	// every read reuses the same barrier key
+	if keys := len(store.history[len(store.history)-1]); keys != 2 {
+		t.Fatalf("expected only the counter and barrier keys but found %d keys", keys)
+	}
+}
+
+func TestStableFreshness(t *testing.T) {
+	store, ctx := staleCounter(t, 3)
+
+	value, err := ReadInt(ctx, NewStableFreshness(2, 10), store.Client(), "counter")
+
+	if err != nil || value != 3 {
+		t.Fatalf("expected stable value 3 but got %d, %v", value, err)
+	}
+}
+
+func TestSessionFreshness(t *testing.T) {
+	store, ctx := staleCounter(t, 3)
+	freshness := NewSessionFreshness(10)
+	freshness.Observe("counter", 2)
+
+	value, err := ReadInt(ctx, freshness, store.Client(), "counter")
+	if err != nil || value < 2 {
+		t.Fatalf("expected a value of at least 2 but got %d, %v", value, err)
+	}
+
+	freshness.Observe("counter", 4)
+	_, err = ReadInt(ctx, freshness, store.Client(), "counter")
+	if maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("expected TemporarilyUnavailable when the store never catches up but got %v", err)
+	}
+}
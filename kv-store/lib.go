@@ -0,0 +1,146 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Store is the subset of maelstrom's KV client used by the servers, so that
+// they can run against an in-memory store in tests.
+type Store interface {
+	Read(ctx context.Context, key string) (any, error)
+	ReadInto(ctx context.Context, key string, v any) error
+	ReadInt(ctx context.Context, key string) (int, error)
+	Write(ctx context.Context, key string, value any) error
+	CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error
+}
+
+var _ Store = (*maelstrom.KV)(nil)
+
+// MemoryStore is a linearizable in-memory Store mimicking maelstrom's lin-kv.
+// Values are kept JSON encoded so reads behave like they would over the wire.
+type MemoryStore struct {
+	lock   *sync.Mutex
+	values map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		lock:   &sync.Mutex{},
+		values: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStore) load(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+
+	return value, nil
+}
+
+func (s *MemoryStore) Read(ctx context.Context, key string) (any, error) {
+	raw, err := s.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	if f, ok := value.(float64); ok {
+		return int(f), nil
+	}
+	return value, nil
+}
+
+func (s *MemoryStore) ReadInto(ctx context.Context, key string, v any) error {
+	raw, err := s.load(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+func (s *MemoryStore) ReadInt(ctx context.Context, key string) (int, error) {
+	v, err := s.Read(ctx, key)
+	i, _ := v.(int)
+	return i, err
+}
+
+func (s *MemoryStore) Write(ctx context.Context, key string, value any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	raw, err := canonicalJSON(value)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values[key] = raw
+	return nil
+}
+
+func (s *MemoryStore) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fromRaw, err := canonicalJSON(from)
+	if err != nil {
+		return err
+	}
+	toRaw, err := canonicalJSON(to)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current, ok := s.values[key]
+	if !ok {
+		if !createIfNotExists {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+
+		s.values[key] = toRaw
+		return nil
+	}
+
+	if string(current) != string(fromRaw) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "current value does not match from")
+	}
+
+	s.values[key] = toRaw
+	return nil
+}
+
+func canonicalJSON(value any) ([]byte, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(decoded)
+}
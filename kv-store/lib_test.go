@@ -0,0 +1,113 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestMemoryStoreReadMissingKey(t *testing.T) {
+	store := NewMemoryStore()
+
+	_, err := store.ReadInt(context.TODO(), "missing")
+
+	if maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("expected KeyDoesNotExist but got %v", err)
+	}
+}
+
+func TestMemoryStoreCompareAndSwap(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.TODO()
+
+	if err := store.CompareAndSwap(ctx, "key", 0, 5, true); err != nil {
+		t.Fatalf("create through CAS failed: %v", err)
+	}
+
+	if err := store.CompareAndSwap(ctx, "key", 0, 7, true); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("expected PreconditionFailed but got %v", err)
+	}
+
+	if err := store.CompareAndSwap(ctx, "key", 5, 7, false); err != nil {
+		t.Fatalf("CAS with matching value failed: %v", err)
+	}
+
+	if value, _ := store.ReadInt(ctx, "key"); value != 7 {
+		t.Fatalf("expected 7 but read %d", value)
+	}
+}
+
+func TestMemoryStoreReadInto(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.TODO()
+	store.Write(ctx, "list", [][2]int{{0, 1}, {1, 2}})
+
+	var values [][2]int
+	if err := store.ReadInto(ctx, "list", &values); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != 2 || values[1] != [2]int{1, 2} {
+		t.Fatalf("unexpected values %v", values)
+	}
+
+	if err := store.CompareAndSwap(ctx, "list", values, append(values, [2]int{2, 3}), false); err != nil {
+		t.Fatalf("CAS on structured value failed: %v", err)
+	}
+}
+
+func TestRetryStopsOnSuccess(t *testing.T) {
+	attempts := 0
+	err := Retry(context.TODO(), DefaultRetryPolicy, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "lost race")
+		}
+		return nil
+	})
+
+	if err != nil || attempts != 3 {
+		t.Fatalf("expected success on third attempt but got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestRetryDoesNotRetryIndefiniteErrors(t *testing.T) {
+	attempts := 0
+	err := Retry(context.TODO(), DefaultRetryPolicy, func(ctx context.Context) error {
+		attempts++
+		return maelstrom.NewRPCError(maelstrom.Timeout, "timed out")
+	})
+
+	if maelstrom.ErrorCode(err) != maelstrom.Timeout || attempts != 1 {
+		t.Fatalf("expected a single attempt failing with Timeout but got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Microsecond, MaxBackoff: time.Millisecond}
+	attempts := 0
+	err := Retry(context.TODO(), policy, func(ctx context.Context) error {
+		attempts++
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "lost race")
+	})
+
+	if maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable || attempts != 4 {
+		t.Fatalf("expected TemporarilyUnavailable after 4 attempts but got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	policy := RetryPolicy{MaxAttempts: 1000, InitialBackoff: time.Millisecond, MaxBackoff: time.Second}
+
+	err := Retry(ctx, policy, func(ctx context.Context) error {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "lost race")
+	})
+
+	if maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("expected TemporarilyUnavailable but got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: 5 * time.Millisecond,
+	MaxBackoff:     200 * time.Millisecond,
+}
+
+// IsRetryable reports whether err is a definite failure that left the store
+// untouched, i.e. a lost CAS race or an explicitly unavailable service.
+// Timeouts are indefinite, the operation might have been applied, so they are
+// never retried.
+func IsRetryable(err error) bool {
+	code := maelstrom.ErrorCode(err)
+	return code == maelstrom.PreconditionFailed || code == maelstrom.TemporarilyUnavailable
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff << min(attempt, 16)
+	if backoff <= 0 || backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	// equal jitter so concurrent writers losing the same CAS spread out
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Retry runs op until it succeeds, fails with an error that isn't retryable,
+// runs out of attempts or ctx is done. Exhausted attempts and cancellation
+// while backing off are reported as a temporarily-unavailable RPC error, as
+// every attempt before them failed definitely.
+func Retry(ctx context.Context, policy RetryPolicy, op func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		if err = op(ctx); err == nil || !IsRetryable(err) {
+			return err
+		}
+
+		if attempt == policy.MaxAttempts-1 {
+			break
+		}
+
+		backoff := policy.backoff(attempt)
+		log.Printf("attempt %d failed with %v, retrying in %v", attempt+1, err, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("cancelled after %d attempts: %v", attempt+1, err))
+		case <-timer.C:
+		}
+	}
+
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("gave up after %d attempts: %v", policy.MaxAttempts, err))
+}
+
+// Unavailable wraps a failed read so Retry tries again. Reads have no side
+// effects, which makes any failure of theirs safe to retry.
+func Unavailable(err error) error {
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+}
@@ -0,0 +1,139 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// StaleStore simulates the stale reads of seq-kv for tests. It keeps every
+// state the store went through and each client reads from its own position
+// in that history. A client's position jumps to the latest state when the
+// client writes and otherwise moves forward by a single state per read.
+type StaleStore struct {
+	lock    *sync.Mutex
+	history []map[string][]byte
+}
+
+type StaleClient struct {
+	store    *StaleStore
+	position int
+}
+
+func NewStaleStore() *StaleStore {
+	return &StaleStore{
+		lock:    &sync.Mutex{},
+		history: []map[string][]byte{{}},
+	}
+}
+
+// Client returns a Store whose reads start from the oldest state.
+func (s *StaleStore) Client() *StaleClient {
+	return &StaleClient{store: s}
+}
+
+func (c *StaleClient) load(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+	value, ok := c.store.history[c.position][key]
+	if c.position < len(c.store.history)-1 {
+		c.position += 1
+	}
+
+	if !ok {
+		return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	return value, nil
+}
+
+func (c *StaleClient) Read(ctx context.Context, key string) (any, error) {
+	raw, err := c.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	if f, ok := value.(float64); ok {
+		return int(f), nil
+	}
+	return value, nil
+}
+
+func (c *StaleClient) ReadInto(ctx context.Context, key string, v any) error {
+	raw, err := c.load(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+func (c *StaleClient) ReadInt(ctx context.Context, key string) (int, error) {
+	v, err := c.Read(ctx, key)
+	i, _ := v.(int)
+	return i, err
+}
+
+// apply must be called with the store lock held.
+func (c *StaleClient) apply(key string, raw []byte) {
+	state := maps.Clone(c.store.history[len(c.store.history)-1])
+	state[key] = raw
+	c.store.history = append(c.store.history, state)
+	c.position = len(c.store.history) - 1
+}
+
+func (c *StaleClient) Write(ctx context.Context, key string, value any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	raw, err := canonicalJSON(value)
+	if err != nil {
+		return err
+	}
+
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+	c.apply(key, raw)
+	return nil
+}
+
+func (c *StaleClient) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fromRaw, err := canonicalJSON(from)
+	if err != nil {
+		return err
+	}
+	toRaw, err := canonicalJSON(to)
+	if err != nil {
+		return err
+	}
+
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+	current, ok := c.store.history[len(c.store.history)-1][key]
+	if !ok && !createIfNotExists {
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+
+	if ok && string(current) != string(fromRaw) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "current value does not match from")
+	}
+
+	c.apply(key, toRaw)
+	return nil
+}
@@ -7,20 +7,35 @@ import (
 	"gossip-glomers/echo"
 	growonlycounter "gossip-glomers/grow-only-counter"
 	kafka "gossip-glomers/kafka"
+	kvstore "gossip-glomers/kv-store"
 	totallyavailable "gossip-glomers/totally-available"
 	uniqueidgeneration "gossip-glomers/unique-id-generation"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
 const (
-	GOSSIP_FREQUENCY     = 5 * time.Second
-	NEIGHBOURS_FREQUENCY = 50 * time.Millisecond
-	GOSSIP_NODES_COUNT   = 5
+	BROADCAST_GOSSIP_FREQUENCY = 100 * time.Millisecond
+	ID_STRATEGY_ENV            = "ID_STRATEGY"
+	REQUEST_TIMEOUT            = 1 * time.Second
+	READ_FRESHNESS_ENV         = "READ_FRESHNESS"
+
+	COUNTER_MODE_ENV         = "COUNTER_MODE"
+	COUNTER_GOSSIP_FREQUENCY = 500 * time.Millisecond
+	COUNTER_FLUSH_FREQUENCY  = 100 * time.Millisecond
+
+	KAFKA_REPLICATION_FACTOR_ENV = "KAFKA_REPLICATION_FACTOR"
+	KAFKA_MIN_IN_SYNC_ENV        = "KAFKA_MIN_IN_SYNC_REPLICAS"
+	KAFKA_STORAGE_DIR_ENV        = "KAFKA_STORAGE_DIR"
+	KAFKA_FSYNC_ENV              = "KAFKA_FSYNC"
+
+	TXN_MAX_BATCH_WRITES_ENV = "TXN_MAX_BATCH_WRITES"
+	TXN_MAX_BATCH_AGE_ENV    = "TXN_MAX_BATCH_AGE"
 )
 
 func main() {
@@ -30,24 +45,92 @@ func main() {
 		return echo.HandleEcho(msg, n)
 	})
 
+	ctx, cancelContext := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancelContext()
+
+	UniqueIdServerSetup(n, ctx)
+	// BroadCastServerSetup(n, ctx)
+
+	GrowOnlyCounterSetup(n, ctx)
+
+	// k := KafkaNodeSetup(n, ctx)
+
+	TotallyAvailableSetup(n, ctx)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// requestContext bounds the KV round trips and retries made for a single client
+// request, so a node doesn't keep working on requests the client gave up on.
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, REQUEST_TIMEOUT)
+}
+
+// pollContext extends the request timeout by the time a poll may wait for
+// records, so long polls aren't cut short after REQUEST_TIMEOUT.
+func pollContext(ctx context.Context, msg *kafka.PollMessage) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, msg.Wait()+REQUEST_TIMEOUT)
+}
+
+func readFreshness(n *maelstrom.Node) kvstore.Freshness {
+	return kvstore.NewFreshness(os.Getenv(READ_FRESHNESS_ENV), n)
+}
+
+func UniqueIdServerSetup(n *maelstrom.Node, ctx context.Context) {
+	if os.Getenv(ID_STRATEGY_ENV) == "range" {
+		s := uniqueidgeneration.NewRangeIdServer(n, uniqueidgeneration.ID_RANGE_SIZE)
+		n.Handle("generate", func(msg maelstrom.Message) error {
+			reqCtx, cancel := requestContext(ctx)
+			defer cancel()
+			return s.HandleMessage(msg, reqCtx)
+		})
+		return
+	}
+
 	s := uniqueidgeneration.NewUniqueIdServer(n)
 	n.Handle("generate", func(msg maelstrom.Message) error {
 		return s.HandleMessage(msg)
 	})
+}
 
-	ctx, cancelContext := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
-	defer cancelContext()
-	// b := BroadCastServerSetup(n, ctx)
-	// defer b.Stop()
+func GrowOnlyCounterSetup(n *maelstrom.Node, ctx context.Context) growonlycounter.Counter {
+	var counter growonlycounter.Counter
+	switch os.Getenv(COUNTER_MODE_ENV) {
+	case "crdt":
+		crdtCounter := growonlycounter.NewCRDTCounterServer(n, COUNTER_GOSSIP_FREQUENCY)
+		n.Handle(crdtCounter.GossipMessageType(), crdtCounter.HandleGossip)
+		go crdtCounter.Gossiper(ctx)
+		counter = &crdtCounter
+	case "pn-crdt":
+		pnCounter := growonlycounter.NewPNCounterServer(n, COUNTER_GOSSIP_FREQUENCY)
+		n.Handle(pnCounter.GossipMessageType(), pnCounter.HandleGossip)
+		go pnCounter.Gossiper(ctx)
+		counter = &pnCounter
+	case "kv-batched":
+		bufferedCounter := growonlycounter.NewBufferedCounterServerWithStore(n, maelstrom.NewSeqKV(n), readFreshness(n), COUNTER_FLUSH_FREQUENCY)
+		go bufferedCounter.FlushServer(ctx)
+		counter = &bufferedCounter
+	default:
+		kvCounter := growonlycounter.NewGrowOnlyCounterServerWithStore(n, maelstrom.NewSeqKV(n), readFreshness(n))
+		counter = &kvCounter
+	}
 
-	gocs := growonlycounter.NewGrowOnlyCounterServer(n)
 	n.Handle("read", func(msg maelstrom.Message) error {
 		readMessage := new(growonlycounter.ReadMessage)
 		if err := json.Unmarshal(msg.Body, readMessage); err != nil {
 			return err
 		}
 
-		return n.Reply(msg, gocs.Read(readMessage, ctx))
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := counter.Read(readMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
 	})
 
 	n.Handle("add", func(msg maelstrom.Message) error {
@@ -56,13 +139,48 @@ func main() {
 			return err
 		}
 
-		return n.Reply(msg, gocs.Add(addMessage, ctx))
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := counter.Add(addMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
 	})
 
-	// k := KafkaNodeSetup(n, ctx)
+	n.Handle("list_counters", func(msg maelstrom.Message) error {
+		listCountersMessage := new(growonlycounter.ListCountersMessage)
+		if err := json.Unmarshal(msg.Body, listCountersMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := counter.ListCounters(listCountersMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	return counter
+}
+
+func TotallyAvailableSetup(n *maelstrom.Node, ctx context.Context) {
+	maxBatchWrites := totallyavailable.MAXIMUM_STORED_WRITES
+	if writes, err := strconv.Atoi(os.Getenv(TXN_MAX_BATCH_WRITES_ENV)); err == nil {
+		maxBatchWrites = writes
+	}
+	maxBatchAge := totallyavailable.MAXIMUM_WRITE_BATCH_AGE
+	if age, err := time.ParseDuration(os.Getenv(TXN_MAX_BATCH_AGE_ENV)); err == nil {
+		maxBatchAge = age
+	}
 
-	ta := totallyavailable.NewTotallyAvailableNode(n)
+	ta := totallyavailable.NewTotallyAvailableNodeWithLimits(n, maxBatchWrites, maxBatchAge)
 	go ta.WriteServer(ctx)
+	go ta.AntiEntropy(ctx)
 	n.Handle("txn", func(msg maelstrom.Message) error {
 		txnMessage := new(totallyavailable.TxnRequest)
 		if err := json.Unmarshal(msg.Body, txnMessage); err != nil {
@@ -79,23 +197,278 @@ func main() {
 		}
 
 		ta.Write(writeMessage.Requests)
-		return nil
+		return n.Reply(msg, writeMessage.Reply())
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	n.Handle("digest", func(msg maelstrom.Message) error {
+		digestMessage := new(totallyavailable.DigestMessage)
+		if err := json.Unmarshal(msg.Body, digestMessage); err != nil {
+			return err
+		}
+
+		return n.Reply(msg, ta.Digest(digestMessage))
+	})
 }
 
 func KafkaNodeSetup(n *maelstrom.Node, ctx context.Context) *kafka.KafkaSever {
-	kafkaServer := kafka.NewKafkaSever(n)
+	kafkaServer := kafka.NewKafkaSeverWithStores(n, maelstrom.NewLinKV(n), maelstrom.NewSeqKV(n), readFreshness(n))
+	if replicationFactor, err := strconv.Atoi(os.Getenv(KAFKA_REPLICATION_FACTOR_ENV)); err == nil {
+		kafkaServer.SetReplicationFactor(replicationFactor)
+	}
+	if minInSync, err := strconv.Atoi(os.Getenv(KAFKA_MIN_IN_SYNC_ENV)); err == nil {
+		kafkaServer.SetMinInSyncReplicas(minInSync)
+	}
+	if dir := os.Getenv(KAFKA_STORAGE_DIR_ENV); dir != "" {
+		if err := kafkaServer.UseFileStorage(dir, os.Getenv(KAFKA_FSYNC_ENV)); err != nil {
+			log.Fatal(err)
+		}
+		go kafkaServer.StorageSyncer(ctx)
+	}
+	go kafkaServer.ReplicaSyncer(ctx)
+	go kafkaServer.GroupReaper(ctx)
+	go kafkaServer.LogCleaner(ctx)
+	go kafkaServer.TransactionReaper(ctx)
+
 	n.Handle("send", func(msg maelstrom.Message) error {
 		sendMessage := new(kafka.SendMessage)
 		if err := json.Unmarshal(msg.Body, sendMessage); err != nil {
 			return err
 		}
 
-		return n.Reply(msg, kafkaServer.Send(sendMessage, ctx))
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.Send(sendMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("send_batch", func(msg maelstrom.Message) error {
+		sendBatchMessage := new(kafka.SendBatchMessage)
+		if err := json.Unmarshal(msg.Body, sendBatchMessage); err != nil {
+			return err
+		}
+
+		sendBatchMessage.Forwarded = kafkaServer.FromNode(msg.Src)
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		return n.Reply(msg, kafkaServer.SendBatch(sendBatchMessage, reqCtx))
+	})
+
+	n.Handle("replicate", func(msg maelstrom.Message) error {
+		replicateMessage := new(kafka.ReplicateMessage)
+		if err := json.Unmarshal(msg.Body, replicateMessage); err != nil {
+			return err
+		}
+
+		reply, err := kafkaServer.Replicate(replicateMessage)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("join_group", func(msg maelstrom.Message) error {
+		joinGroupMessage := new(kafka.JoinGroupMessage)
+		if err := json.Unmarshal(msg.Body, joinGroupMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.JoinGroup(joinGroupMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("heartbeat", func(msg maelstrom.Message) error {
+		heartbeatMessage := new(kafka.HeartbeatMessage)
+		if err := json.Unmarshal(msg.Body, heartbeatMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.Heartbeat(heartbeatMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("leave_group", func(msg maelstrom.Message) error {
+		leaveGroupMessage := new(kafka.LeaveGroupMessage)
+		if err := json.Unmarshal(msg.Body, leaveGroupMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.LeaveGroup(leaveGroupMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("begin_txn", func(msg maelstrom.Message) error {
+		beginTxnMessage := new(kafka.BeginTxnMessage)
+		if err := json.Unmarshal(msg.Body, beginTxnMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.BeginTxn(beginTxnMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("add_txn_key", func(msg maelstrom.Message) error {
+		addTxnKeyMessage := new(kafka.AddTxnKeyMessage)
+		if err := json.Unmarshal(msg.Body, addTxnKeyMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.AddTxnKey(addTxnKeyMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	endTxn := func(msg maelstrom.Message) error {
+		endTxnMessage := new(kafka.EndTxnMessage)
+		if err := json.Unmarshal(msg.Body, endTxnMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.EndTxn(endTxnMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	}
+	n.Handle("commit_txn", endTxn)
+	n.Handle("abort_txn", endTxn)
+
+	n.Handle("write_marker", func(msg maelstrom.Message) error {
+		writeMarkerMessage := new(kafka.WriteMarkerMessage)
+		if err := json.Unmarshal(msg.Body, writeMarkerMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.WriteMarker(writeMarkerMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("list_keys", func(msg maelstrom.Message) error {
+		listKeysMessage := new(kafka.ListKeysMessage)
+		if err := json.Unmarshal(msg.Body, listKeysMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		return n.Reply(msg, kafkaServer.ListKeys(listKeysMessage, reqCtx))
+	})
+
+	n.Handle("describe_key", func(msg maelstrom.Message) error {
+		describeKeyMessage := new(kafka.DescribeKeyMessage)
+		if err := json.Unmarshal(msg.Body, describeKeyMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.DescribeKey(describeKeyMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("truncate_key", func(msg maelstrom.Message) error {
+		truncateKeyMessage := new(kafka.TruncateKeyMessage)
+		if err := json.Unmarshal(msg.Body, truncateKeyMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.TruncateKey(truncateKeyMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("delete_key", func(msg maelstrom.Message) error {
+		deleteKeyMessage := new(kafka.DeleteKeyMessage)
+		if err := json.Unmarshal(msg.Body, deleteKeyMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.DeleteKey(deleteKeyMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("set_retention", func(msg maelstrom.Message) error {
+		setRetentionMessage := new(kafka.SetRetentionMessage)
+		if err := json.Unmarshal(msg.Body, setRetentionMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.SetRetention(setRetentionMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("owner", func(msg maelstrom.Message) error {
+		ownerMessage := new(kafka.OwnerMessage)
+		if err := json.Unmarshal(msg.Body, ownerMessage); err != nil {
+			return err
+		}
+
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		return n.Reply(msg, kafkaServer.Owner(ownerMessage, reqCtx))
 	})
 
 	n.Handle("poll", func(msg maelstrom.Message) error {
@@ -104,7 +477,32 @@ func KafkaNodeSetup(n *maelstrom.Node, ctx context.Context) *kafka.KafkaSever {
 			return err
 		}
 
-		return n.Reply(msg, kafkaServer.Poll(pollMessage, ctx))
+		pollMessage.Forwarded = kafkaServer.FromNode(msg.Src)
+		reqCtx, cancel := pollContext(ctx, pollMessage)
+		defer cancel()
+		reply, err := kafkaServer.Poll(pollMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
+	})
+
+	n.Handle("offsets_for_times", func(msg maelstrom.Message) error {
+		offsetsForTimesMessage := new(kafka.OffsetsForTimesMessage)
+		if err := json.Unmarshal(msg.Body, offsetsForTimesMessage); err != nil {
+			return err
+		}
+
+		offsetsForTimesMessage.Forwarded = kafkaServer.FromNode(msg.Src)
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.OffsetsForTimes(offsetsForTimesMessage, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
 	})
 
 	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
@@ -113,7 +511,14 @@ func KafkaNodeSetup(n *maelstrom.Node, ctx context.Context) *kafka.KafkaSever {
 			return err
 		}
 
-		return n.Reply(msg, kafkaServer.CommitOffsets(commitOffsets, ctx))
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		reply, err := kafkaServer.CommitOffsets(commitOffsets, reqCtx)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, reply)
 	})
 
 	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
@@ -122,14 +527,16 @@ func KafkaNodeSetup(n *maelstrom.Node, ctx context.Context) *kafka.KafkaSever {
 			return err
 		}
 
-		return n.Reply(msg, kafkaServer.ListCommitedOffsets(listCommittedOffsets, ctx))
+		reqCtx, cancel := requestContext(ctx)
+		defer cancel()
+		return n.Reply(msg, kafkaServer.ListCommitedOffsets(listCommittedOffsets, reqCtx))
 	})
 
 	return kafkaServer
 }
 
 func BroadCastServerSetup(n *maelstrom.Node, ctx context.Context) broadcast.BroadcastServer {
-	b := broadcast.NewBroadcastServer(n, GOSSIP_FREQUENCY, NEIGHBOURS_FREQUENCY)
+	b := broadcast.NewBroadcastServer(n, BROADCAST_GOSSIP_FREQUENCY)
 	n.Handle("read", func(msg maelstrom.Message) error {
 		body := new(broadcast.ReadMessage)
 		if err := json.Unmarshal(msg.Body, body); err != nil {
@@ -154,25 +561,11 @@ func BroadCastServerSetup(n *maelstrom.Node, ctx context.Context) broadcast.Broa
 			return err
 		}
 
-		reply, replyBack := b.Broadcast(body, msg.Src)
-		if !replyBack {
-			return nil
-		}
-		return n.Reply(msg, reply)
-	})
-
-	n.Handle("gossip", func(msg maelstrom.Message) error {
-		body := new(broadcast.GossipMessage)
-		if err := json.Unmarshal(msg.Body, body); err != nil {
-			return err
-		}
-
-		b.Gossip(body.Messages, msg.Src)
-		return nil
+		return n.Reply(msg, b.Broadcast(body))
 	})
 
-	go b.SendToNeighbours(ctx)
-	go b.Gossiper(ctx, GOSSIP_NODES_COUNT)
+	n.Handle(b.GossipMessageType(), b.HandleGossip)
+	go b.Gossiper(ctx)
 
 	return b
 }
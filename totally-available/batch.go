@@ -0,0 +1,36 @@
+package totallyavailable
+
+import (
+	"maps"
+	"slices"
+)
+
+// writeBatch collects the local writes waiting to be replicated. Writes of
+// the same key are coalesced into the one with the greatest version, the
+// others would lose the merge on every replica anyway.
+type writeBatch struct {
+	writes map[int]WriteKeyRequest
+}
+
+func newWriteBatch() *writeBatch {
+	return &writeBatch{writes: make(map[int]WriteKeyRequest)}
+}
+
+// add adds request to the batch and returns the size of the batch, the
+// number of keys written.
+func (b *writeBatch) add(request WriteKeyRequest) int {
+	if batched, ok := b.writes[request.Key]; !ok || batched.Timestamp.Less(request.Timestamp) {
+		b.writes[request.Key] = request
+	}
+	return len(b.writes)
+}
+
+// take empties the batch and returns its writes ordered by key.
+func (b *writeBatch) take() []WriteKeyRequest {
+	requests := make([]WriteKeyRequest, 0, len(b.writes))
+	for _, key := range slices.Sorted(maps.Keys(b.writes)) {
+		requests = append(requests, b.writes[key])
+	}
+	b.writes = make(map[int]WriteKeyRequest)
+	return requests
+}
@@ -0,0 +1,62 @@
+package totallyavailable
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestWriteBatchCoalescesKeys(t *testing.T) {
+	batch := newWriteBatch()
+	latest := NewWriteKeyRequest(1, 11, Timestamp{Time: 2, Node: "n0"})
+	other := NewWriteKeyRequest(2, 20, Timestamp{Time: 1, Node: "n0"})
+
+	batch.add(NewWriteKeyRequest(1, 10, Timestamp{Time: 1, Node: "n0"}))
+	batch.add(other)
+	if size := batch.add(latest); size != 2 {
+		t.Errorf("expected 2 keys in the batch but got %d", size)
+	}
+	// an older write arriving late doesn't replace the latest one
+	if size := batch.add(NewWriteKeyRequest(1, 9, Timestamp{Time: 1, Logical: 1, Node: "n0"})); size != 2 {
+		t.Errorf("expected 2 keys in the batch but got %d", size)
+	}
+
+	if requests := batch.take(); !slices.Equal(requests, []WriteKeyRequest{latest, other}) {
+		t.Errorf("expected the latest write of each key but got %v", requests)
+	}
+	if requests := batch.take(); len(requests) != 0 {
+		t.Errorf("expected an empty batch after taking it but got %v", requests)
+	}
+}
+
+// queued returns the number of writes queued for peer.
+func queued(ta *TotallyAvailableNode, peer string) int {
+	ta.outbox.lock.Lock()
+	defer ta.outbox.lock.Unlock()
+	return len(ta.outbox.pending[peer])
+}
+
+func TestWriteServerFlushesOldBatches(t *testing.T) {
+	n := maelstrom.NewNode()
+	n.Init("n0", []string{"n0", "n1"})
+	ta := NewTotallyAvailableNodeWithLimits(n, 10, 20*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ta.WriteServer(ctx)
+
+	start := time.Now()
+	ta.Transaction(&TxnRequest{Type: "txn", Operations: []Operation{{"w", float64(1), float64(10)}, {"w", float64(2), float64(20)}}})
+
+	for deadline := start.Add(time.Second); queued(&ta, "n1") < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a batch below maxBatchWrites to be flushed once it is maxBatchAge old")
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < ta.maxBatchAge {
+		t.Errorf("expected the batch to be held for %v but it was flushed after %v", ta.maxBatchAge, elapsed)
+	}
+}
@@ -0,0 +1,76 @@
+package totallyavailable
+
+import (
+	"sync"
+	"time"
+
+	"gossip-glomers/crdt"
+)
+
+// Timestamp is a hybrid logical clock reading: the wall clock in
+// milliseconds, a counter ordering events within the same millisecond or
+// while the wall clock lags behind a timestamp received from another node,
+// and the node that took it to break the remaining ties. It is the timestamp
+// of the crdt LWW types, so registers order writes the same way.
+type Timestamp = crdt.Timestamp
+
+// Clock is a hybrid logical clock. Its timestamps never go backwards, and a
+// timestamp taken after observing another one is greater than it, so the
+// order of timestamps respects causality even when wall clocks are skewed.
+type Clock struct {
+	lock   *sync.Mutex
+	last   Timestamp
+	node   func() string
+	wallMs func() int64
+}
+
+// NewClock returns a clock stamping timestamps with the id node returns,
+// resolved on each reading as node ids are only known once a node is
+// initialized.
+func NewClock(node func() string) *Clock {
+	return newClockWithWall(node, func() int64 { return time.Now().UnixMilli() })
+}
+
+func newClockWithWall(node func() string, wallMs func() int64) *Clock {
+	return &Clock{lock: &sync.Mutex{}, node: node, wallMs: wallMs}
+}
+
+// Now returns a timestamp for a local event, greater than every timestamp
+// the clock returned or observed before.
+func (c *Clock) Now() Timestamp {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.advance(c.last)
+	return c.last
+}
+
+// Observe advances the clock past a timestamp received from another node and
+// returns the clock's new reading.
+func (c *Clock) Observe(remote Timestamp) Timestamp {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.advance(remote)
+	return c.last
+}
+
+// advance moves the clock to the greatest of the wall clock, its last reading
+// and remote, bumping the logical counter when the physical time doesn't
+// move. The caller holds c.lock.
+func (c *Clock) advance(remote Timestamp) {
+	wall := c.wallMs()
+	physical := max(wall, c.last.Time, remote.Time)
+
+	logical := uint32(0)
+	switch {
+	case physical == c.last.Time && physical == remote.Time:
+		logical = max(c.last.Logical, remote.Logical) + 1
+	case physical == c.last.Time:
+		logical = c.last.Logical + 1
+	case physical == remote.Time:
+		logical = remote.Logical + 1
+	}
+
+	c.last = Timestamp{Time: physical, Logical: logical, Node: c.node()}
+}
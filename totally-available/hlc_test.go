@@ -0,0 +1,47 @@
+package totallyavailable
+
+import "testing"
+
+func TestClockNeverGoesBackwards(t *testing.T) {
+	wall := int64(100)
+	clock := newClockWithWall(func() string { return "n0" }, func() int64 { return wall })
+
+	first := clock.Now()
+	second := clock.Now()
+	if !first.Less(second) || second.Time != 100 || second.Logical != 1 {
+		t.Errorf("expected a later timestamp in the same millisecond but got %v after %v", second, first)
+	}
+
+	// the wall clock was set back
+	wall = 50
+	third := clock.Now()
+	if !second.Less(third) {
+		t.Errorf("expected %v after %v", third, second)
+	}
+
+	wall = 200
+	if now := clock.Now(); now.Time != 200 || now.Logical != 0 {
+		t.Errorf("expected the clock to follow the wall clock but got %v", now)
+	}
+}
+
+func TestClockObserve(t *testing.T) {
+	clock := newClockWithWall(func() string { return "n0" }, func() int64 { return 100 })
+
+	// a node whose wall clock is ahead
+	remote := Timestamp{Time: 500, Logical: 3, Node: "n1"}
+	observed := clock.Observe(remote)
+	if !remote.Less(observed) || observed.Time != 500 || observed.Logical != 4 {
+		t.Errorf("expected a reading after %v but got %v", remote, observed)
+	}
+
+	// writes after observing remote are ordered after it despite the skew
+	if now := clock.Now(); !remote.Less(now) || !observed.Less(now) {
+		t.Errorf("expected %v after %v", now, observed)
+	}
+
+	// the node breaks ties
+	if !(Timestamp{Time: 1, Node: "n0"}).Less(Timestamp{Time: 1, Node: "n1"}) {
+		t.Error("expected equal clock readings to be ordered by node")
+	}
+}
@@ -12,26 +12,46 @@ import (
 )
 
 const (
-	TICKER_TIME           = 1 * time.Second
-	MAXIMUM_STORED_WRITES = 25
+	TICKER_TIME             = 1 * time.Second
+	MAXIMUM_STORED_WRITES   = 25
+	MAXIMUM_WRITE_BATCH_AGE = 200 * time.Millisecond
 )
 
 type TotallyAvailableNode struct {
+	// registers holds a crdt.LWWRegister per key, versioned by the timestamp
+	// of the write that set it. The write with the greatest version wins, so
+	// replicas that saw the same writes hold the same registers whatever
+	// order the writes arrived in. A stored register is never modified, a
+	// write stores an updated copy, so readers don't need the key's lock.
+	registers      *sync.Map
 	keyLocks       *sync.Map
-	kv             *sync.Map
-	lastWrite      *sync.Map
 	node           *maelstrom.Node
+	clock          *Clock
+	outbox         *outbox
 	requestChannel chan WriteKeyRequest
+	maxBatchWrites int
+	maxBatchAge    time.Duration
 }
 
 func NewTotallyAvailableNode(n *maelstrom.Node) TotallyAvailableNode {
+	return NewTotallyAvailableNodeWithLimits(n, MAXIMUM_STORED_WRITES, MAXIMUM_WRITE_BATCH_AGE)
+}
+
+// NewTotallyAvailableNodeWithLimits returns a node replicating its writes
+// once maxBatchWrites keys were written or the oldest write waited for
+// maxBatchAge, whichever comes first.
+func NewTotallyAvailableNodeWithLimits(n *maelstrom.Node, maxBatchWrites int, maxBatchAge time.Duration) TotallyAvailableNode {
 	log.SetOutput(os.Stderr)
+	maxBatchWrites = max(maxBatchWrites, 1)
 	return TotallyAvailableNode{
 		keyLocks:       &sync.Map{},
-		kv:             &sync.Map{},
-		lastWrite:      &sync.Map{},
+		registers:      &sync.Map{},
 		node:           n,
-		requestChannel: make(chan WriteKeyRequest, MAXIMUM_STORED_WRITES*2),
+		clock:          NewClock(n.ID),
+		outbox:         newOutbox(),
+		requestChannel: make(chan WriteKeyRequest, maxBatchWrites*2),
+		maxBatchWrites: maxBatchWrites,
+		maxBatchAge:    maxBatchAge,
 	}
 }
 
@@ -42,14 +62,15 @@ func (ta *TotallyAvailableNode) lockForKey(key int) *sync.RWMutex {
 }
 
 func (ta *TotallyAvailableNode) performWrite(key int, value int) Operation {
-	ta.kv.Store(key, value)
+	version := ta.clock.Now()
+	ta.merge(key, value, version)
+	ta.requestChannel <- NewWriteKeyRequest(key, value, version)
 
-	log.Printf("key:%d set to %d", key, value)
 	return OperationResult("w", key, value)
 }
 
 func (ta *TotallyAvailableNode) performRead(key int) Operation {
-	value, ok := ta.kv.Load(key)
+	value, _, ok := ta.register(key)
 	if !ok {
 		log.Printf("key:%d not found", key)
 		return OperationResult("r", key, nil)
@@ -128,9 +149,7 @@ func (ta *TotallyAvailableNode) Transaction(msg *TxnRequest) TxnReply {
 		isWrite := IsWrite(op)
 
 		if isWrite {
-			value := GetValue(op)
 			results[idx] = ta.performWrite(key, GetValue(op))
-			ta.requestChannel <- NewWriteKeyRequest(key, value)
 		} else {
 			results[idx] = ta.performRead(key)
 		}
@@ -139,42 +158,46 @@ func (ta *TotallyAvailableNode) Transaction(msg *TxnRequest) TxnReply {
 	return msg.Reply(results)
 }
 
+// WriteServer batches the local writes and replicates a batch once it holds
+// maxBatchWrites keys or its oldest write waited for maxBatchAge, so a quiet
+// node doesn't hold back its last writes. Queued writes are delivered again
+// every TICKER_TIME until the peers acknowledge them.
 func (ta *TotallyAvailableNode) WriteServer(ctx context.Context) {
 	ticker := time.NewTicker(TICKER_TIME)
-	storedWrites := make([]WriteKeyRequest, 0, MAXIMUM_STORED_WRITES)
+	defer ticker.Stop()
+	batch := newWriteBatch()
+	var flushTimer *time.Timer
+	var flushAt <-chan time.Time
+
+	flush := func() {
+		if flushTimer != nil {
+			flushTimer.Stop()
+		}
+		flushTimer, flushAt = nil, nil
+
+		requests := batch.take()
+		log.Printf("replicating a batch of %d writes", len(requests))
+		ta.replicate(requests)
+		ta.deliver(ctx)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
-			break
+			return
 		case <-ticker.C:
-			if len(storedWrites) < MAXIMUM_STORED_WRITES || len(storedWrites) == 0 {
-				continue
-			}
-
-			writeMessage := WriteMessage{MessageType: "write", Requests: storedWrites}
-			wg := &sync.WaitGroup{}
-			for _, dest := range ta.node.NodeIDs() {
-				if dest == ta.node.ID() {
-					continue
-				}
-
-				wg.Add(1)
-				go func(dest string, wg *sync.WaitGroup) {
-					ta.node.Send(dest, writeMessage)
-					log.Printf("sent to %s: %v", dest, writeMessage)
-					wg.Done()
-				}(dest, wg)
-			}
-			wg.Wait()
-
-			storedWrites = make([]WriteKeyRequest, 0, MAXIMUM_STORED_WRITES)
-		case writeRequest, open := <-ta.requestChannel:
-			if !open {
-				break
-			}
-
+			ta.deliver(ctx)
+		case <-flushAt:
+			flush()
+		case writeRequest := <-ta.requestChannel:
 			log.Printf("received new write request %v", writeRequest)
-			storedWrites = append(storedWrites, writeRequest)
+			size := batch.add(writeRequest)
+			if size >= ta.maxBatchWrites {
+				flush()
+			} else if flushAt == nil {
+				flushTimer = time.NewTimer(ta.maxBatchAge)
+				flushAt = flushTimer.C
+			}
 		}
 	}
 }
@@ -189,12 +212,7 @@ func (ta *TotallyAvailableNode) Write(requests []WriteKeyRequest) {
 	defer ta.unlockLocks(locks, writeKeys, false)
 
 	for _, req := range requests {
-		lastWrite, loaded := ta.lastWrite.LoadOrStore(req.Key, req.Timestamp)
-		if !loaded && lastWrite.(uint) > req.Timestamp {
-			log.Printf("key:%d not updated as %d > %d", req.Key, lastWrite.(uint), req.Timestamp)
-			continue
-		}
-
-		ta.performWrite(req.Key, req.Value)
+		ta.clock.Observe(req.Timestamp)
+		ta.merge(req.Key, req.Value, req.Timestamp)
 	}
 }
@@ -0,0 +1,124 @@
+package totallyavailable
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newTestNode(id string, ids []string) *TotallyAvailableNode {
+	n := maelstrom.NewNode()
+	n.Init(id, ids)
+	ta := NewTotallyAvailableNode(n)
+	return &ta
+}
+
+// values returns the value of every key the node holds.
+func values(ta *TotallyAvailableNode) map[int]int {
+	values := make(map[int]int)
+	ta.rangeRegisters(func(key int, value int, _ Timestamp) {
+		values[key] = value
+	})
+	return values
+}
+
+// transact runs a write of value to each key on ta and returns the writes it
+// queued for replication.
+func transact(ta *TotallyAvailableNode, writes map[int]int) []WriteKeyRequest {
+	operations := make([]Operation, 0, len(writes))
+	for key, value := range writes {
+		operations = append(operations, Operation{"w", float64(key), float64(value)})
+	}
+	ta.Transaction(&TxnRequest{Type: "txn", Operations: operations})
+
+	requests := make([]WriteKeyRequest, 0, len(writes))
+	for range len(writes) {
+		requests = append(requests, <-ta.requestChannel)
+	}
+	return requests
+}
+
+func TestTransactionReadsLatestWrite(t *testing.T) {
+	ta := newTestNode("n0", []string{"n0"})
+
+	ta.Transaction(&TxnRequest{Type: "txn", Operations: []Operation{{"w", float64(1), float64(10)}, {"w", float64(1), float64(11)}}})
+	<-ta.requestChannel
+	<-ta.requestChannel
+
+	reply := ta.Transaction(&TxnRequest{Type: "txn", Operations: []Operation{{"r", float64(1), nil}, {"r", float64(2), nil}}})
+	if reply.Operations[0][2] != 11 || reply.Operations[1][2] != nil {
+		t.Errorf("expected key 1 to be 11 and key 2 to be missing but got %v", reply.Operations)
+	}
+}
+
+func TestWriteKeepsNewerVersion(t *testing.T) {
+	ta := newTestNode("n0", []string{"n0", "n1"})
+	newer := Timestamp{Time: 200, Node: "n1"}
+
+	ta.Write([]WriteKeyRequest{NewWriteKeyRequest(1, 20, newer)})
+	ta.Write([]WriteKeyRequest{NewWriteKeyRequest(1, 10, Timestamp{Time: 100, Node: "n1"})})
+
+	if value, version, _ := ta.register(1); value != 20 || version != newer {
+		t.Errorf("expected the newer write to be kept but got %d at %v", value, version)
+	}
+
+	// local writes after a replicated one are newer even if the local clock lags
+	transact(ta, map[int]int{1: 30})
+	if value, version, _ := ta.register(1); value != 30 || !newer.Less(version) {
+		t.Errorf("expected the local write to win but got %d at %v", value, version)
+	}
+}
+
+func TestNodesConvergeRegardlessOfDeliveryOrder(t *testing.T) {
+	ids := []string{"n0", "n1", "n2"}
+	for seed := range 50 {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			random := rand.New(rand.NewPCG(uint64(seed), 0))
+			nodes := make([]*TotallyAvailableNode, 0, len(ids))
+			for _, id := range ids {
+				nodes = append(nodes, newTestNode(id, ids))
+			}
+
+			// each node runs transactions on a few overlapping keys and
+			// sees some of the other nodes' writes in between
+			sent := make([][]WriteKeyRequest, len(nodes))
+			for range 20 {
+				i := random.IntN(len(nodes))
+				writes := make(map[int]int)
+				for range 1 + random.IntN(3) {
+					writes[random.IntN(5)] = random.IntN(1000)
+				}
+				sent[i] = append(sent[i], transact(nodes[i], writes)...)
+
+				if j := random.IntN(len(nodes)); j != i && random.IntN(2) == 0 {
+					nodes[j].Write(sent[i][random.IntN(len(sent[i])):])
+				}
+			}
+
+			// every node gets every write, shuffled and some of them twice
+			for i, node := range nodes {
+				requests := make([]WriteKeyRequest, 0)
+				for j := range nodes {
+					if j != i {
+						requests = append(requests, sent[j]...)
+					}
+				}
+				requests = append(requests, requests[:random.IntN(len(requests)+1)]...)
+				random.Shuffle(len(requests), func(a, b int) { requests[a], requests[b] = requests[b], requests[a] })
+				for _, request := range requests {
+					node.Write([]WriteKeyRequest{request})
+				}
+			}
+
+			expected := values(nodes[0])
+			for i, node := range nodes[1:] {
+				if actual := values(node); !maps.Equal(actual, expected) {
+					t.Errorf("expected %s to hold %v like n0 but it holds %v", ids[i+1], expected, actual)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,5 @@
 package totallyavailable
 
-import "time"
-
 type Operation = [3]any
 
 func OperationResult(kind string, key int, value any) Operation {
@@ -53,16 +51,40 @@ type WriteMessage struct {
 	Requests    []WriteKeyRequest `json:"requests"`
 }
 
+func (m *WriteMessage) Reply() WriteMessageReply {
+	return WriteMessageReply{MessageType: "write_ok"}
+}
+
+type WriteMessageReply struct {
+	MessageType string `json:"type"`
+}
+
 type WriteKeyRequest struct {
-	Key       int  `json:"key"`
-	Value     int  `json:"value"`
-	Timestamp uint `json:"timestamp"`
+	Key       int       `json:"key"`
+	Value     int       `json:"value"`
+	Timestamp Timestamp `json:"timestamp"`
 }
 
-func NewWriteKeyRequest(key int, value int) WriteKeyRequest {
+func NewWriteKeyRequest(key int, value int, timestamp Timestamp) WriteKeyRequest {
 	return WriteKeyRequest{
 		Key:       key,
 		Value:     value,
-		Timestamp: uint(time.Now().Unix()),
+		Timestamp: timestamp,
 	}
 }
+
+// DigestMessage carries the version of every key a node holds.
+type DigestMessage struct {
+	MessageType string            `json:"type"`
+	Versions    map[int]Timestamp `json:"versions"`
+}
+
+func (m *DigestMessage) Reply(requests []WriteKeyRequest, missing []int) DigestMessageReply {
+	return DigestMessageReply{MessageType: "digest_ok", Requests: requests, Missing: missing}
+}
+
+type DigestMessageReply struct {
+	MessageType string            `json:"type"`
+	Requests    []WriteKeyRequest `json:"requests"`
+	Missing     []int             `json:"missing"`
+}
@@ -0,0 +1,46 @@
+package totallyavailable
+
+import (
+	"log"
+
+	"gossip-glomers/crdt"
+)
+
+// merge applies a write of key at version, local or replicated, unless the
+// register already holds the same or a newer version. It reports whether the
+// register changed. The caller holds the write lock of key.
+func (ta *TotallyAvailableNode) merge(key int, value int, version Timestamp) bool {
+	updated := crdt.NewLWWRegister[int]()
+	if current, ok := ta.registers.Load(key); ok {
+		updated = current.(*crdt.LWWRegister[int]).Clone()
+	}
+
+	if !updated.Set(value, version) {
+		_, current, _ := updated.Get()
+		log.Printf("key:%d not updated as %v is not older than %v", key, current, version)
+		return false
+	}
+
+	ta.registers.Store(key, updated)
+	log.Printf("key:%d set to %d at %v", key, value, version)
+	return true
+}
+
+// register returns the value of key and its version.
+func (ta *TotallyAvailableNode) register(key int) (int, Timestamp, bool) {
+	current, ok := ta.registers.Load(key)
+	if !ok {
+		return 0, Timestamp{}, false
+	}
+	return current.(*crdt.LWWRegister[int]).Get()
+}
+
+// rangeRegisters calls f with the value and version of every key the node
+// holds.
+func (ta *TotallyAvailableNode) rangeRegisters(f func(key int, value int, version Timestamp)) {
+	ta.registers.Range(func(key, current any) bool {
+		value, version, _ := current.(*crdt.LWWRegister[int]).Get()
+		f(key.(int), value, version)
+		return true
+	})
+}
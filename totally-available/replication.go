@@ -0,0 +1,206 @@
+package totallyavailable
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DELIVERY_TIMEOUT       = 1 * time.Second
+	ANTI_ENTROPY_FREQUENCY = 5 * time.Second
+)
+
+// outbox holds the writes not yet acknowledged by each peer. Only the latest
+// write of a key is kept, replicas only need the winning version, so a peer
+// that is down for a while costs at most one write per key.
+type outbox struct {
+	lock     *sync.Mutex
+	pending  map[string]map[int]WriteKeyRequest
+	inFlight map[string]bool
+}
+
+func newOutbox() *outbox {
+	return &outbox{lock: &sync.Mutex{}, pending: make(map[string]map[int]WriteKeyRequest), inFlight: make(map[string]bool)}
+}
+
+// add queues requests for peer, replacing older queued writes of their keys.
+func (o *outbox) add(peer string, requests []WriteKeyRequest) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	pending, ok := o.pending[peer]
+	if !ok {
+		pending = make(map[int]WriteKeyRequest)
+		o.pending[peer] = pending
+	}
+	for _, request := range requests {
+		if queued, ok := pending[request.Key]; !ok || queued.Timestamp.Less(request.Timestamp) {
+			pending[request.Key] = request
+		}
+	}
+}
+
+// take returns the writes queued for peer ordered by key and marks peer as in
+// flight until done is called. It returns false if there is nothing to send
+// or a delivery to peer is still in flight.
+func (o *outbox) take(peer string) ([]WriteKeyRequest, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.inFlight[peer] || len(o.pending[peer]) == 0 {
+		return nil, false
+	}
+
+	o.inFlight[peer] = true
+	requests := make([]WriteKeyRequest, 0, len(o.pending[peer]))
+	for _, key := range slices.Sorted(maps.Keys(o.pending[peer])) {
+		requests = append(requests, o.pending[peer][key])
+	}
+	return requests, true
+}
+
+// done ends the delivery of sent to peer. Acknowledged writes are dropped
+// unless a newer write of their key was queued meanwhile, the others are
+// sent again on the next delivery.
+func (o *outbox) done(peer string, sent []WriteKeyRequest, acknowledged bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.inFlight[peer] = false
+	if !acknowledged {
+		return
+	}
+	for _, request := range sent {
+		if o.pending[peer][request.Key] == request {
+			delete(o.pending[peer], request.Key)
+		}
+	}
+}
+
+// peers returns the other nodes of the cluster.
+func (ta *TotallyAvailableNode) peers() []string {
+	return slices.DeleteFunc(ta.node.NodeIDs(), func(node string) bool { return node == ta.node.ID() })
+}
+
+// replicate queues requests for every peer.
+func (ta *TotallyAvailableNode) replicate(requests []WriteKeyRequest) {
+	for _, peer := range ta.peers() {
+		ta.outbox.add(peer, requests)
+	}
+}
+
+// deliver sends the queued writes to each peer without a delivery in flight.
+// Writes stay queued until the peer acknowledges them, so writes lost to a
+// partition or a dropped message are sent again on a later delivery.
+func (ta *TotallyAvailableNode) deliver(ctx context.Context) {
+	for _, peer := range ta.peers() {
+		requests, ok := ta.outbox.take(peer)
+		if !ok {
+			continue
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, DELIVERY_TIMEOUT)
+			defer cancel()
+			_, err := ta.node.SyncRPC(ctx, peer, WriteMessage{MessageType: "write", Requests: requests})
+			if err != nil {
+				log.Printf("failed to deliver %d writes to %s: %v", len(requests), peer, err)
+			}
+			ta.outbox.done(peer, requests, err == nil)
+		}()
+	}
+}
+
+// AntiEntropy periodically compares the versions of every key with a random
+// peer and exchanges the writes either side is missing, so replicas converge
+// even if writes were lost before reaching the outbox, for example when a
+// node crashed.
+func (ta *TotallyAvailableNode) AntiEntropy(ctx context.Context) {
+	ticker := time.NewTicker(ANTI_ENTROPY_FREQUENCY)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			peers := ta.peers()
+			if len(peers) == 0 {
+				continue
+			}
+
+			ta.exchangeDigest(ctx, peers[rand.Intn(len(peers))])
+		}
+	}
+}
+
+func (ta *TotallyAvailableNode) exchangeDigest(ctx context.Context, peer string) {
+	ctx, cancel := context.WithTimeout(ctx, DELIVERY_TIMEOUT)
+	defer cancel()
+	reply, err := ta.node.SyncRPC(ctx, peer, DigestMessage{MessageType: "digest", Versions: ta.versions()})
+	if err != nil {
+		log.Printf("failed to exchange digest with %s: %v", peer, err)
+		return
+	}
+
+	digestReply := new(DigestMessageReply)
+	if err := json.Unmarshal(reply.Body, digestReply); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return
+	}
+
+	ta.reconcile(peer, *digestReply)
+}
+
+// versions returns the version of every key the node holds.
+func (ta *TotallyAvailableNode) versions() map[int]Timestamp {
+	versions := make(map[int]Timestamp)
+	ta.rangeRegisters(func(key int, _ int, version Timestamp) {
+		versions[key] = version
+	})
+	return versions
+}
+
+// Digest compares the versions of a peer with the local ones. The reply holds
+// the writes the peer is missing and the keys the peer holds newer writes of.
+func (ta *TotallyAvailableNode) Digest(msg *DigestMessage) DigestMessageReply {
+	newer := make([]WriteKeyRequest, 0)
+	ta.rangeRegisters(func(key int, value int, version Timestamp) {
+		if peerVersion, ok := msg.Versions[key]; !ok || peerVersion.Less(version) {
+			newer = append(newer, NewWriteKeyRequest(key, value, version))
+		}
+	})
+
+	missing := make([]int, 0)
+	for key, version := range msg.Versions {
+		if _, current, ok := ta.register(key); !ok || current.Less(version) {
+			missing = append(missing, key)
+		}
+	}
+	slices.Sort(missing)
+
+	return msg.Reply(newer, missing)
+}
+
+// reconcile applies the writes of a digest reply from peer and queues the
+// keys peer is missing for it.
+func (ta *TotallyAvailableNode) reconcile(peer string, reply DigestMessageReply) {
+	if len(reply.Requests) > 0 {
+		ta.Write(reply.Requests)
+	}
+
+	requests := make([]WriteKeyRequest, 0, len(reply.Missing))
+	for _, key := range reply.Missing {
+		if value, version, ok := ta.register(key); ok {
+			requests = append(requests, NewWriteKeyRequest(key, value, version))
+		}
+	}
+	if len(requests) > 0 {
+		log.Printf("%s is missing %d writes", peer, len(requests))
+		ta.outbox.add(peer, requests)
+	}
+}
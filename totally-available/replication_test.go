@@ -0,0 +1,69 @@
+package totallyavailable
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestOutboxRetriesUntilAcknowledged(t *testing.T) {
+	o := newOutbox()
+	first := NewWriteKeyRequest(1, 10, Timestamp{Time: 1, Node: "n0"})
+	newer := NewWriteKeyRequest(1, 11, Timestamp{Time: 2, Node: "n0"})
+	o.add("n1", []WriteKeyRequest{first, NewWriteKeyRequest(2, 20, Timestamp{Time: 1, Node: "n0"})})
+	o.add("n1", []WriteKeyRequest{newer})
+
+	sent, ok := o.take("n1")
+	if !ok || !slices.Equal(sent, []WriteKeyRequest{newer, NewWriteKeyRequest(2, 20, Timestamp{Time: 1, Node: "n0"})}) {
+		t.Fatalf("expected the latest write of each key but got %v", sent)
+	}
+	if _, ok := o.take("n1"); ok {
+		t.Error("expected no second delivery while one is in flight")
+	}
+
+	// a lost delivery is sent again
+	o.done("n1", sent, false)
+	if again, ok := o.take("n1"); !ok || !slices.Equal(again, sent) {
+		t.Errorf("expected %v to be sent again but got %v", sent, again)
+	}
+
+	// a write queued while in flight outlives the acknowledgement
+	latest := NewWriteKeyRequest(2, 21, Timestamp{Time: 3, Node: "n0"})
+	o.add("n1", []WriteKeyRequest{latest})
+	o.done("n1", sent, true)
+	if rest, ok := o.take("n1"); !ok || !slices.Equal(rest, []WriteKeyRequest{latest}) {
+		t.Errorf("expected only %v to be left but got %v", latest, rest)
+	}
+}
+
+func TestDigestExchangeConverges(t *testing.T) {
+	ids := []string{"n0", "n1"}
+	n0, n1 := newTestNode("n0", ids), newTestNode("n1", ids)
+
+	// the nodes were partitioned and their writes never arrived
+	transact(n0, map[int]int{1: 10, 2: 20})
+	transact(n1, map[int]int{2: 21, 3: 30})
+	n0.outbox = newOutbox()
+
+	// the digest goes over the wire
+	encoded, err := json.Marshal(DigestMessage{MessageType: "digest", Versions: n0.versions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := new(DigestMessage)
+	if err := json.Unmarshal(encoded, digest); err != nil {
+		t.Fatal(err)
+	}
+
+	n0.reconcile("n1", n1.Digest(digest))
+	missing, ok := n0.outbox.take("n1")
+	if !ok {
+		t.Fatal("expected writes n1 is missing to be queued")
+	}
+	n1.Write(missing)
+
+	if !maps.Equal(values(n0), values(n1)) || len(values(n0)) != 3 {
+		t.Errorf("expected n0 and n1 to hold the same 3 keys but n0 holds %v and n1 %v", values(n0), values(n1))
+	}
+}
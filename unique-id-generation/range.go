@@ -0,0 +1,176 @@
+package uniqueidgeneration
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	ID_RANGE_KEY                string        = "id-range"
+	ID_RANGE_SIZE               int           = 1000
+	ID_RANGE_ALLOCATION_TIMEOUT time.Duration = 5 * time.Second
+)
+
+type idRange struct {
+	next int
+	end  int
+}
+
+func (r idRange) remaining() int {
+	return r.end - r.next
+}
+
+// rangeRefill is an allocation of the next id range in flight, done is closed
+// once it finished with err.
+type rangeRefill struct {
+	done chan struct{}
+	err  error
+}
+
+// RangeIdServer hands out ids from ranges reserved in lin-kv through CAS on a
+// single high-water mark, so ids are dense across the cluster and strictly
+// increasing on every node. A range size of 1 makes them strictly increasing
+// across the cluster at the cost of a lin-kv round trip per id.
+type RangeIdServer struct {
+	n           *maelstrom.Node
+	kv          kvstore.Store
+	rangeSize   int
+	mutex       *sync.Mutex
+	current     idRange
+	reserved    *idRange
+	refill      *rangeRefill
+	retryPolicy kvstore.RetryPolicy
+}
+
+func NewRangeIdServer(n *maelstrom.Node, rangeSize int) RangeIdServer {
+	return NewRangeIdServerWithStore(n, maelstrom.NewLinKV(n), rangeSize)
+}
+
+func NewRangeIdServerWithStore(n *maelstrom.Node, kv kvstore.Store, rangeSize int) RangeIdServer {
+	return RangeIdServer{
+		n:           n,
+		kv:          kv,
+		rangeSize:   max(rangeSize, 1),
+		mutex:       &sync.Mutex{},
+		retryPolicy: kvstore.DefaultRetryPolicy,
+	}
+}
+
+func (s *RangeIdServer) HandleMessage(m maelstrom.Message, ctx context.Context) error {
+	receivedMessage := new(UniqueIdMessage)
+	if err := json.Unmarshal(m.Body, receivedMessage); err != nil {
+		return err
+	}
+
+	uniqueId, err := s.GenerateId(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.n.Reply(m, receivedMessage.Reply(strconv.Itoa(uniqueId)))
+}
+
+func (s *RangeIdServer) GenerateId(ctx context.Context) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for s.current.remaining() == 0 {
+		if s.reserved != nil {
+			s.current, s.reserved = *s.reserved, nil
+			break
+		}
+
+		// wait for the allocation without holding the lock, every request
+		// running out of ids shares the one in flight
+		refill := s.startRefill(ctx)
+		s.mutex.Unlock()
+		select {
+		case <-refill.done:
+		case <-ctx.Done():
+			s.mutex.Lock()
+			return 0, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "id range not allocated in time")
+		}
+		s.mutex.Lock()
+
+		if refill.err != nil {
+			return 0, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "unable to allocate id range")
+		}
+	}
+
+	id := s.current.next
+	s.current.next += 1
+
+	// reserve the next range in the background once half of the current one is
+	// used, so short partitions from lin-kv are absorbed by the spare range
+	if s.rangeSize > 1 && s.current.remaining() <= s.rangeSize/2 && s.reserved == nil {
+		s.startRefill(ctx)
+	}
+
+	return id, nil
+}
+
+// startRefill returns the allocation in flight, starting one if there is none.
+// The caller holds s.mutex.
+func (s *RangeIdServer) startRefill(ctx context.Context) *rangeRefill {
+	if s.refill == nil {
+		s.refill = &rangeRefill{done: make(chan struct{})}
+		go s.reserve(context.WithoutCancel(ctx), s.refill)
+	}
+	return s.refill
+}
+
+// reserve outlives the request that triggered it, so it gets its own deadline
+func (s *RangeIdServer) reserve(ctx context.Context, refill *rangeRefill) {
+	ctx, cancel := context.WithTimeout(ctx, ID_RANGE_ALLOCATION_TIMEOUT)
+	defer cancel()
+	allocated, err := s.allocateRange(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer close(refill.done)
+	s.refill, refill.err = nil, err
+	if err != nil {
+		log.Printf("failed to allocate id range: %v", err)
+		return
+	}
+
+	if allocated.next < s.current.next {
+		log.Printf("dropping reserved id range [%d, %d) behind current range", allocated.next, allocated.end)
+		return
+	}
+
+	s.reserved = &allocated
+}
+
+func (s *RangeIdServer) allocateRange(ctx context.Context) (idRange, error) {
+	allocated := idRange{}
+	err := kvstore.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+		start, err := s.kv.ReadInt(ctx, ID_RANGE_KEY)
+		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			return kvstore.Unavailable(err)
+		}
+
+		err = s.kv.CompareAndSwap(ctx, ID_RANGE_KEY, start, start+s.rangeSize, true)
+		if err != nil {
+			log.Printf("id range starting at %d not allocated: %v", start, err)
+			return err
+		}
+
+		allocated = idRange{next: start, end: start + s.rangeSize}
+		return nil
+	})
+	if err != nil {
+		return idRange{}, err
+	}
+
+	log.Printf("allocated id range [%d, %d)", allocated.next, allocated.end)
+	return allocated, nil
+}
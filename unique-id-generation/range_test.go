@@ -0,0 +1,125 @@
+package uniqueidgeneration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kvstore "gossip-glomers/kv-store"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+type unavailableStore struct {
+	kvstore.Store
+}
+
+func (unavailableStore) ReadInt(ctx context.Context, key string) (int, error) {
+	return 0, errors.New("partitioned")
+}
+
+// heldStore holds every read until release is closed, reporting reads on
+// entered.
+type heldStore struct {
+	*kvstore.MemoryStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s heldStore) ReadInt(ctx context.Context, key string) (int, error) {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.MemoryStore.ReadInt(ctx, key)
+}
+
+func TestRangeIdsShareAllocation(t *testing.T) {
+	store := heldStore{MemoryStore: kvstore.NewMemoryStore(), entered: make(chan struct{}, 10), release: make(chan struct{})}
+	server := NewRangeIdServerWithStore(maelstrom.NewNode(), store, 10)
+
+	ids := make(chan int, 3)
+	for range 3 {
+		go func() {
+			id, err := server.GenerateId(context.TODO())
+			if err != nil {
+				t.Error(err)
+			}
+			ids <- id
+		}()
+	}
+	<-store.entered
+
+	// the allocation in flight doesn't hold the lock
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if _, err := server.GenerateId(ctx); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Errorf("expected a cancelled request to give up but got %v", err)
+	}
+
+	close(store.release)
+	seen := make(map[int]struct{})
+	for range 3 {
+		seen[<-ids] = struct{}{}
+	}
+
+	if len(seen) != 3 || len(store.entered) != 0 {
+		t.Errorf("expected 3 ids from a single allocation but got %v after %d more reads", seen, len(store.entered))
+	}
+}
+
+func TestRangeIdsAreDenseAcrossServers(t *testing.T) {
+	store := kvstore.NewMemoryStore()
+	first := NewRangeIdServerWithStore(maelstrom.NewNode(), store, 10)
+	second := NewRangeIdServerWithStore(maelstrom.NewNode(), store, 10)
+	ctx := context.TODO()
+
+	seen := make(map[int]struct{})
+	for range 5 {
+		for _, server := range []*RangeIdServer{&first, &second} {
+			id, err := server.GenerateId(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := seen[id]; ok {
+				t.Fatalf("id %d generated twice", id)
+			}
+			seen[id] = struct{}{}
+		}
+	}
+
+	for id := range seen {
+		if id < 0 || id >= 20 {
+			t.Errorf("id %d outside of the two allocated ranges", id)
+		}
+	}
+}
+
+func TestRangeIdsStrictlyIncreasing(t *testing.T) {
+	server := NewRangeIdServerWithStore(maelstrom.NewNode(), kvstore.NewMemoryStore(), 4)
+	ctx := context.TODO()
+
+	previous := -1
+	for range 50 {
+		id, err := server.GenerateId(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id <= previous {
+			t.Fatalf("id %d generated after %d", id, previous)
+		}
+		previous = id
+	}
+}
+
+func TestRangeIdUnavailableStore(t *testing.T) {
+	server := NewRangeIdServerWithStore(maelstrom.NewNode(), unavailableStore{}, 10)
+	server.retryPolicy = kvstore.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Microsecond, MaxBackoff: time.Millisecond}
+
+	_, err := server.GenerateId(context.TODO())
+
+	if maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("expected TemporarilyUnavailable but got %v", err)
+	}
+}